@@ -0,0 +1,162 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The names of the fields of a frame which Grafana converts to annotations
+const (
+	annotationTimeField    = "time"
+	annotationTimeEndField = "timeEnd"
+	annotationTitleField   = "title"
+	annotationTextField    = "text"
+	annotationTagsField    = "tags"
+)
+
+// getAnnotationFields returns the fixed schema of an annotations query
+func getAnnotationFields() []field {
+	return []field{
+		{Name: annotationTimeEndField, Type: data.FieldTypeNullableTime},
+		{Name: annotationTitleField, Type: data.FieldTypeNullableString},
+		{Name: annotationTextField, Type: data.FieldTypeNullableString},
+		{Name: annotationTagsField, Type: data.FieldTypeNullableString},
+	}
+}
+
+// annotationQueryModel produces a single frame with a row for each document, with the fields Grafana expects
+// for annotations. Each field is read from a configurable field of the document. Only the time is required.
+type annotationQueryModel struct {
+	timestampFieldName   string
+	timestampFieldFormat string
+	timeEndFieldName     string
+	titleFieldName       string
+	textFieldName        string
+	tagsFieldName        string
+	fields               []field
+}
+
+var _ = resolvedQueryModel(&annotationQueryModel{})
+
+func (m *annotationQueryModel) makeFrame(id string, labels data.Labels) (*data.Frame, error) {
+	frame := data.NewFrame("annotations", data.NewField(annotationTimeField, nil, []time.Time{}))
+	for _, f := range m.fields {
+		newField, err := m.makeField(f, labels, 0)
+		if err != nil {
+			return nil, err
+		}
+		frame.Fields = append(frame.Fields, newField)
+	}
+	return frame, nil
+}
+
+func (m *annotationQueryModel) getFields() ([]field, int) {
+	return m.fields, 1
+}
+
+func (m *annotationQueryModel) setFields(fields []field) {
+	m.fields = fields
+}
+
+func (m *annotationQueryModel) makeField(f field, labels data.Labels, length int) (*data.Field, error) {
+	newField := data.NewFieldFromFieldType(f.Type, length)
+	newField.Name = f.Name
+	return newField, nil
+}
+
+func (m *annotationQueryModel) getLabels(doc timestepDocument) (data.Labels, string) {
+	return make(data.Labels), ""
+}
+
+func (m *annotationQueryModel) getValues(doc timestepDocument) ([]interface{}, error) {
+	values := make([]interface{}, 1+len(m.fields))
+
+	timestamp, ok := lookupPath(doc, m.timestampFieldName)
+	if !ok {
+		return nil, fmt.Errorf("All documents must have the Timestamp Field present")
+	}
+	var err error
+	values[0], err = convertTimestamp(timestamp, m.timestampFieldFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	valueValues := values[1:]
+	for ix, f := range m.fields {
+		var value interface{}
+		switch f.Name {
+		case annotationTimeEndField:
+			value, err = m.getTimeEnd(doc)
+		case annotationTitleField:
+			value, err = lookupText(doc, m.titleFieldName)
+		case annotationTextField:
+			value, err = lookupText(doc, m.textFieldName)
+		case annotationTagsField:
+			value, err = getAnnotationTags(doc, m.tagsFieldName)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Failed to convert value for %s", f.Name))
+		}
+		valueValues[ix] = value
+	}
+	return values, nil
+}
+
+// getTimeEnd returns the end time of a region annotation, or nil if the annotation is not a region
+func (m *annotationQueryModel) getTimeEnd(doc timestepDocument) (*time.Time, error) {
+	if m.timeEndFieldName == "" {
+		return nil, nil
+	}
+	timestamp, ok := lookupPath(doc, m.timeEndFieldName)
+	if !ok || timestamp == nil {
+		return nil, nil
+	}
+	timeEnd, err := convertTimestamp(timestamp, m.timestampFieldFormat)
+	if err != nil {
+		return nil, err
+	}
+	return &timeEnd, nil
+}
+
+// getAnnotationTags converts the value of a field to the tags of an annotation. An array produces a tag for
+// each of its elements, and any other value produces a single tag. Frames cannot contain arrays of strings,
+// so the tags are joined with commas, which Grafana splits them by.
+func getAnnotationTags(doc timestepDocument, fieldName string) (*string, error) {
+	if fieldName == "" {
+		return nil, nil
+	}
+	value, ok := lookupPath(doc, fieldName)
+	if !ok || value == nil {
+		return nil, nil
+	}
+	var elems []interface{}
+	switch v := value.(type) {
+	case bson.A:
+		elems = v
+	case []interface{}:
+		elems = v
+	default:
+		elems = []interface{}{v}
+	}
+	tags := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		if elem == nil {
+			continue
+		}
+		tag, err := convertValue(elem, data.FieldTypeString, true)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag.(string))
+	}
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	joined := strings.Join(tags, ",")
+	return &joined, nil
+}
@@ -0,0 +1,83 @@
+package plugin_test
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	bsonprim "go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Annotations", func() {
+	qm := &plugin.QueryModel{
+		QueryType:              "Annotations",
+		TimestampField:         "start",
+		AnnotationTimeEndField: "end",
+		AnnotationTitleField:   "title",
+		AnnotationTextField:    "details",
+		AnnotationTagsField:    "tags",
+	}
+	start := nowMillis
+	end := start.Add(time.Minute)
+
+	DescribeTable("should produce",
+		func(doc bson.D, expectedTimeEnd *time.Time, expectedTitle, expectedText, expectedTags *string) {
+			res := plugin.ReadDocuments(qm, []bson.D{append(bson.D{{Key: "start", Value: bsonprim.NewDateTimeFromTime(start)}}, doc...)})
+			Expect(res.Error).ToNot(HaveOccurred())
+			Expect(res.Frames).To(HaveLen(1))
+			frame := res.Frames[0]
+			Expect(fieldNames(frame)).To(Equal([]string{"time", "timeEnd", "title", "text", "tags"}))
+			Expect(frame.Fields[0].At(0)).To(BeTemporally("==", start))
+			for ix, expected := range []interface{}{expectedTimeEnd, expectedTitle, expectedText, expectedTags} {
+				actual := frame.Fields[ix+1].At(0)
+				switch v := expected.(type) {
+				case *time.Time:
+					if v == nil {
+						Expect(actual).To(BeNil())
+						continue
+					}
+					Expect(*actual.(*time.Time)).To(BeTemporally("==", *v))
+				case *string:
+					if v == nil {
+						Expect(actual).To(BeNil())
+						continue
+					}
+					Expect(actual).To(Equal(v))
+				}
+			}
+		},
+		Entry("a region with every field",
+			bson.D{
+				{Key: "end", Value: bsonprim.NewDateTimeFromTime(end)},
+				{Key: "title", Value: "Deploy"},
+				{Key: "details", Value: "v1.2.3"},
+				{Key: "tags", Value: bson.A{"deploy", "prod"}},
+			},
+			&end, stringPointer("Deploy"), stringPointer("v1.2.3"), stringPointer("deploy,prod"),
+		),
+		Entry("a single tag from a scalar, and text from a document",
+			bson.D{
+				{Key: "details", Value: bson.D{{Key: "version", Value: "v1"}}},
+				{Key: "tags", Value: int32(1)},
+			},
+			nil, nil, stringPointer(`{"version":"v1"}`), stringPointer("1"),
+		),
+		Entry("no tags from an empty array, skipping null elements",
+			bson.D{{Key: "tags", Value: bson.A{nil}}},
+			nil, nil, nil, nil,
+		),
+		Entry("only a point in time without the optional fields",
+			bson.D{},
+			nil, nil, nil, nil,
+		),
+	)
+
+	It("Should require the timestamp field", func() {
+		res := plugin.ReadDocuments(qm, []bson.D{{{Key: "title", Value: "Deploy"}}})
+		Expect(res.Error).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,186 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Reducers which can be used to combine the values of a field within each automatic time bucket
+const (
+	bucketReducerAvg   = "avg"
+	bucketReducerSum   = "sum"
+	bucketReducerMin   = "min"
+	bucketReducerMax   = "max"
+	bucketReducerCount = "count"
+	bucketReducerFirst = "first"
+	bucketReducerLast  = "last"
+
+	defaultBucketReducer = bucketReducerAvg
+)
+
+var bucketReducerAccumulators = map[string]string{
+	bucketReducerAvg:   "$avg",
+	bucketReducerSum:   "$sum",
+	bucketReducerMin:   "$min",
+	bucketReducerMax:   "$max",
+	bucketReducerFirst: "$first",
+	bucketReducerLast:  "$last",
+}
+
+// getBucketInterval determines the width of each automatic time bucket from the query interval,
+// widened if necessary so that no more than the maximum number of data points are produced
+func getBucketInterval(r queryRange) time.Duration {
+	interval := r.Interval
+	if r.MaxDataPoints > 0 {
+		minInterval := r.To.Sub(r.From) / time.Duration(r.MaxDataPoints)
+		if interval < minInterval {
+			interval = minInterval
+		}
+	}
+	interval = interval.Round(time.Millisecond)
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	return interval
+}
+
+// getBucketStartExpression produces an aggregation expression which evaluates to the start of the time bucket
+// containing a date. Buckets are aligned to the Unix epoch. Date arithmetic is used instead of $dateTrunc,
+// which requires MongoDB 5.0
+func getBucketStartExpression(date interface{}, interval time.Duration) bson.D {
+	sinceEpoch := bson.D{bson.E{Key: "$subtract", Value: bson.A{date, bsonPrim.DateTime(0)}}}
+	return bson.D{bson.E{
+		Key: "$subtract",
+		Value: bson.A{
+			date,
+			bson.D{bson.E{Key: "$mod", Value: bson.A{sinceEpoch, interval.Milliseconds()}}},
+		},
+	}}
+}
+
+// setBucketedFieldTypes changes the types of value fields which are averaged to float64, keeping their nullability,
+// as the average of integers is rarely an integer
+func (m *QueryModel) setBucketedFieldTypes(fields []field) {
+	for ix := range fields {
+		reducer, err := m.getBucketReducer(ix)
+		if err != nil || reducer != bucketReducerAvg {
+			continue
+		}
+		if fields[ix].Type.Nullable() {
+			fields[ix].Type = data.FieldTypeNullableFloat64
+		} else {
+			fields[ix].Type = data.FieldTypeFloat64
+		}
+	}
+}
+
+func (m *QueryModel) getBucketReducer(ix int) (string, error) {
+	reducer := defaultBucketReducer
+	if ix < len(m.AutoBucketReducers) && m.AutoBucketReducers[ix] != "" {
+		reducer = strings.ToLower(m.AutoBucketReducers[ix])
+	}
+	if _, ok := bucketReducerAccumulators[reducer]; !ok && reducer != bucketReducerCount {
+		return "", fmt.Errorf(
+			"Invalid reducer for %s: %s, must be one of %s, %s, %s, %s, %s, %s, %s",
+			m.ValueFields[ix], reducer,
+			bucketReducerAvg, bucketReducerSum, bucketReducerMin, bucketReducerMax, bucketReducerCount, bucketReducerFirst, bucketReducerLast,
+		)
+	}
+	return reducer, nil
+}
+
+// getTimestampExpression produces an aggregation expression which evaluates to the timestamp of a document as a date
+func (m *QueryModel) getTimestampExpression() (interface{}, error) {
+	if m.usesBSONTimestamps() {
+		return bson.D{bson.E{Key: "$toDate", Value: fieldPathExpression(m.TimestampField)}}, nil
+	}
+	if m.TimestampFormat == "" {
+		return fieldPathExpression(m.TimestampField), nil
+	}
+	convertedFormat, err := ConvertGoTimeFormatToMongo(m.TimestampFormat)
+	if err != nil {
+		return nil, err
+	}
+	return bson.D{bson.E{
+		Key: "$dateFromString",
+		Value: bson.D{
+			bson.E{Key: "dateString", Value: fieldPathExpression(m.TimestampField)},
+			bson.E{Key: "format", Value: convertedFormat},
+		},
+	}}, nil
+}
+
+// getAutoBucketPipelineStages produces stages which group documents into time buckets sized from the
+// query interval, one per unique combination of labels, and combine each value field with its reducer.
+// The results are projected back to the original timestamp, label and value field names, so that
+// they can be parsed the same as un-bucketed documents.
+// Field names may be paths, which $group does not allow, so positional names are used while grouping
+func (m *QueryModel) getAutoBucketPipelineStages(r queryRange) (mongo.Pipeline, error) {
+	if len(m.ValueFields) == 0 {
+		return nil, fmt.Errorf("Value Fields must be specified to use automatic time buckets")
+	}
+	timestamp, err := m.getTimestampExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	groupID := bson.D{bson.E{
+		Key:   "t",
+		Value: getBucketStartExpression(timestamp, getBucketInterval(r)),
+	}}
+	project := bson.D{
+		bson.E{Key: "_id", Value: 0},
+		bson.E{Key: m.TimestampField, Value: "$_id.t"},
+	}
+	for ix, name := range m.LabelFields {
+		key := fmt.Sprintf("l%d", ix)
+		groupID = append(groupID, bson.E{Key: key, Value: fieldPathExpression(name)})
+		project = append(project, bson.E{Key: name, Value: "$_id." + key})
+	}
+
+	group := bson.D{bson.E{Key: "_id", Value: groupID}}
+	needsSort := false
+	for ix, name := range m.ValueFields {
+		reducer, err := m.getBucketReducer(ix)
+		if err != nil {
+			return nil, err
+		}
+		key := fmt.Sprintf("v%d", ix)
+		var accumulator bson.D
+		if reducer == bucketReducerCount {
+			accumulator = bson.D{bson.E{Key: "$sum", Value: 1}}
+		} else {
+			accumulator = bson.D{bson.E{Key: bucketReducerAccumulators[reducer], Value: fieldPathExpression(name)}}
+		}
+		if reducer == bucketReducerFirst || reducer == bucketReducerLast {
+			needsSort = true
+		}
+		group = append(group, bson.E{Key: key, Value: accumulator})
+		project = append(project, bson.E{Key: name, Value: "$" + key})
+	}
+
+	stages := mongo.Pipeline{}
+	if needsSort {
+		// first and last are only meaningful if the documents in each bucket are in time order
+		stages = append(stages, m.getTimeSortPipelineStage())
+	}
+	stages = append(stages,
+		bson.D{bson.E{Key: "$group", Value: group}},
+		bson.D{bson.E{Key: "$project", Value: project}},
+	)
+	return stages, nil
+}
+
+// getBucketStart returns the start of the time bucket containing a time. Buckets are aligned to the
+// Unix epoch, in the same way as getBucketStartExpression
+func getBucketStart(t time.Time, interval time.Duration) time.Time {
+	millis := t.UnixNano() / int64(time.Millisecond)
+	millis -= millis % interval.Milliseconds()
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC()
+}
@@ -0,0 +1,37 @@
+package plugin_test
+
+import (
+	"time"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GetBucketInterval", func() {
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	DescribeTable("should choose",
+		func(rangeLength time.Duration, interval time.Duration, maxDataPoints int64, expected time.Duration) {
+			Expect(plugin.GetBucketInterval(from, from.Add(rangeLength), interval, maxDataPoints)).To(Equal(expected))
+		},
+		Entry("the query interval if it produces few enough points", time.Hour, time.Minute, int64(1000), time.Minute),
+		Entry("a wider interval if there would be too many points", time.Hour, time.Second, int64(60), time.Minute),
+		Entry("the query interval if there is no limit on points", time.Hour, time.Second, int64(0), time.Second),
+		Entry("a whole number of milliseconds", time.Second, time.Duration(0), int64(3), 333*time.Millisecond),
+		Entry("at least one millisecond", time.Millisecond, time.Duration(0), int64(1000), time.Millisecond),
+	)
+})
+
+var _ = Describe("GetBucketStart", func() {
+	DescribeTable("should align",
+		func(t time.Time, interval time.Duration, expected time.Time) {
+			Expect(plugin.GetBucketStart(t, interval)).To(Equal(expected.UTC()))
+		},
+		Entry("to the start of a bucket", time.Unix(125, 0), time.Minute, time.Unix(120, 0)),
+		Entry("a time already at the start of a bucket to itself", time.Unix(120, 0), time.Minute, time.Unix(120, 0)),
+		Entry("to the Unix epoch for intervals which do not divide a day", time.Unix(100, 0), 7*time.Second, time.Unix(98, 0)),
+		Entry("to whole milliseconds", time.Unix(10, 1500000), time.Millisecond, time.Unix(10, 1000000)),
+	)
+})
@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxChangeStreamBatch is the most change events which are sent to a stream as a single set of frames
+const maxChangeStreamBatch = 1000
+
+// changeEvent is the subset of a change stream event used by this plugin
+type changeEvent struct {
+	FullDocument bson.D `bson:"fullDocument"`
+}
+
+// getChangeStreamPipeline produces the pipeline which filters the events of a change stream
+func (m *QueryModel) getChangeStreamPipeline() (mongo.Pipeline, error) {
+	pipeline := mongo.Pipeline{}
+	match, err := m.parseExtJSONDocument("change stream match", m.ChangeStreamMatch, queryRange{})
+	if err != nil {
+		return nil, err
+	}
+	if len(match) != 0 {
+		pipeline = append(pipeline, bson.D{bson.E{Key: "$match", Value: match}})
+	}
+	return pipeline, nil
+}
+
+// watch opens a change stream on the collection, or on the whole database if no collection is specified.
+// If a resume token is provided, the stream starts after the event it identifies.
+func (m *QueryModel) watch(ctx context.Context, client *mongo.Client, resumeToken bson.Raw) (*mongo.ChangeStream, error) {
+	pipeline, err := m.getChangeStreamPipeline()
+	if err != nil {
+		return nil, err
+	}
+	opts := mongoOpts.ChangeStream().SetFullDocument(mongoOpts.UpdateLookup)
+	if resumeToken != nil {
+		opts.SetStartAfter(resumeToken)
+	}
+	log.DefaultLogger.Debug("Opening change stream", "database", m.Database, "collection", m.Collection, "pipeline", pipeline, "resumeToken", resumeToken)
+	database := client.Database(m.Database)
+	if m.Collection == "" {
+		return database.Watch(ctx, pipeline, opts)
+	}
+	return database.Collection(m.Collection).Watch(ctx, pipeline, opts)
+}
+
+// runChangeStream sends the full document of each change event to the stream until the context is cancelled.
+// The resume token of the last event sent is kept, so that if the change stream fails, or the stream is stopped
+// and started again, it resumes without dropping events.
+func (d *MongoDBDatasource) runChangeStream(ctx context.Context, path string, qm *QueryModel, sender *backend.StreamSender) error {
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	var resumeToken bson.Raw
+	if token, ok := d.resumeTokens.Load(path); ok {
+		resumeToken = token.(bson.Raw)
+	}
+	stream, err := qm.watch(ctx, client, resumeToken)
+	if err != nil {
+		return errors.Wrap(err, "Failed to open change stream")
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		docs := make([]bson.D, 0, 1)
+		for {
+			event := changeEvent{}
+			err = stream.Decode(&event)
+			if err != nil {
+				return errors.Wrap(err, "Failed to decode change event")
+			}
+			// Events such as deletes have no document
+			if event.FullDocument != nil {
+				docs = append(docs, event.FullDocument)
+			}
+			if len(docs) >= maxChangeStreamBatch || !stream.TryNext(ctx) {
+				break
+			}
+		}
+		if len(docs) != 0 {
+			err = sendDocuments(ctx, qm, newSliceSource(docs), sender)
+			if err != nil {
+				return err
+			}
+		}
+		// The token is only valid until the next event is decoded
+		d.resumeTokens.Store(path, append(bson.Raw(nil), stream.ResumeToken()...))
+	}
+	return stream.Err()
+}
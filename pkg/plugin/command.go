@@ -0,0 +1,145 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// runCommand runs an arbitrary database command, such as dbStats or listCollections.
+// If the command returns a cursor, every document from the cursor is a result.
+// Otherwise, the result is the reply document, or the value at the result path within it.
+// If that value is an array, each element is a result.
+func (m *QueryModel) runCommand(ctx context.Context, database *mongo.Database, r queryRange) (documentSource, error) {
+	command, err := m.getCommand(r)
+	if err != nil {
+		return nil, err
+	}
+
+	log.DefaultLogger.Debug("Running command", "command", command, "cursor", m.CommandCursor)
+	if m.CommandCursor {
+		cursor, err := database.RunCommandCursor(ctx, command)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to send command to mongo")
+		}
+		return cursor, nil
+	}
+
+	reply := bson.D{}
+	err = database.RunCommand(ctx, command).Decode(&reply)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to send command to mongo")
+	}
+	docs, err := getCommandResultDocuments(reply, m.CommandResultPath)
+	if err != nil {
+		return nil, err
+	}
+	return newSliceSource(docs), nil
+}
+
+// readOnlyCommands are the commands which may be run by a Command query, by their lowercased name.
+// Dashboards are readable by far more users than the datasource credentials are meant for,
+// so commands which write, such as aggregate with $out, or which administer the server, are rejected.
+// getCmdLineOpts and getLog are rejected as well, as the server's options and log can contain secrets
+var readOnlyCommands = map[string]struct{}{
+	"buildinfo":        {},
+	"collstats":        {},
+	"connectionstatus": {},
+	"connpoolstats":    {},
+	"count":            {},
+	"datasize":         {},
+	"dbstats":          {},
+	"distinct":         {},
+	"find":             {},
+	"getparameter":     {},
+	"hello":            {},
+	"hostinfo":         {},
+	"ismaster":         {},
+	"listcollections":  {},
+	"listdatabases":    {},
+	"listindexes":      {},
+	"listshards":       {},
+	"lockinfo":         {},
+	"ping":             {},
+	"replsetgetconfig": {},
+	"replsetgetstatus": {},
+	"serverstatus":     {},
+	"top":              {},
+	"whatsmyuri":       {},
+}
+
+// getCommand parses the command document, with its macros expanded, and checks that it is read-only.
+// The name of a command is the first key of its document
+func (m *QueryModel) getCommand(r queryRange) (bson.D, error) {
+	command, err := m.parseExtJSONDocument("command", m.Command, r)
+	if err != nil {
+		return nil, err
+	}
+	if len(command) == 0 {
+		return nil, errors.New("Command must be specified")
+	}
+	if _, ok := readOnlyCommands[strings.ToLower(command[0].Key)]; !ok {
+		allowed := make([]string, 0, len(readOnlyCommands))
+		for name := range readOnlyCommands {
+			allowed = append(allowed, name)
+		}
+		sort.Strings(allowed)
+		return nil, fmt.Errorf("Command %s is not allowed, only the following read-only commands can be run: %s", command[0].Key, strings.Join(allowed, ", "))
+	}
+	return command, nil
+}
+
+// getCommandResultDocuments extracts the result documents from a command reply.
+// Scalar values are placed in a document under the last element of their path.
+// The whole reply is returned without the status and cluster metadata every reply has,
+// such as ok, operationTime, and $clusterTime
+func getCommandResultDocuments(reply bson.D, path string) ([]bson.D, error) {
+	if path == "" {
+		doc := make(bson.D, 0, len(reply))
+		for _, elem := range reply {
+			if elem.Key == "ok" || elem.Key == "operationTime" || strings.HasPrefix(elem.Key, "$") {
+				continue
+			}
+			doc = append(doc, elem)
+		}
+		return []bson.D{doc}, nil
+	}
+	replyDoc, _ := flattenDocument(reply, 0)
+	result, ok := lookupPath(replyDoc, path)
+	if !ok {
+		return nil, errors.New("Command reply has no field " + path)
+	}
+	var elems []interface{}
+	switch v := result.(type) {
+	case bson.A:
+		elems = v
+	case []interface{}:
+		elems = v
+	default:
+		elems = []interface{}{v}
+	}
+	docs := make([]bson.D, len(elems))
+	for ix, elem := range elems {
+		if doc, isDoc := elem.(bson.D); isDoc {
+			docs[ix] = doc
+			continue
+		}
+		docs[ix] = bson.D{bson.E{Key: lastPathElement(path), Value: elem}}
+	}
+	return docs, nil
+}
+
+func lastPathElement(path string) string {
+	for ix := len(path) - 1; ix >= 0; ix-- {
+		if path[ix] == '.' {
+			return path[ix+1:]
+		}
+	}
+	return path
+}
@@ -0,0 +1,72 @@
+package plugin_test
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	bsonprim "go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GetCommand", func() {
+	DescribeTable("should check",
+		func(command string, allowed bool) {
+			_, err := plugin.GetCommand(&plugin.QueryModel{QueryType: "Command", Command: command})
+			if !allowed {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+		},
+		Entry("a read-only command", `{"dbStats": 1}`, true),
+		Entry("a read-only command in a different case", `{"buildinfo": 1}`, true),
+		Entry("a command which drops a database", `{"dropDatabase": 1}`, false),
+		Entry("a command which administers the server", `{"shutdown": 1}`, false),
+		Entry("a command which creates a user", `{"createUser": "u", "pwd": "p", "roles": []}`, false),
+		Entry("an aggregation which can write", `{"aggregate": "c", "pipeline": [{"$out": "d"}], "cursor": {}}`, false),
+		Entry("a command which reveals the server's options", `{"getCmdLineOpts": 1}`, false),
+		Entry("a command which reveals the server's log", `{"getLog": "global"}`, false),
+		Entry("an empty command", `{}`, false),
+	)
+})
+
+var _ = Describe("GetCommandResultDocuments", func() {
+	reply := bson.D{
+		{Key: "db", Value: "my_db"},
+		{Key: "collections", Value: int32(2)},
+		{Key: "cursor", Value: bson.D{{Key: "firstBatch", Value: bson.A{
+			bson.D{{Key: "name", Value: "a"}},
+			bson.D{{Key: "name", Value: "b"}},
+		}}}},
+		{Key: "ok", Value: float64(1)},
+		{Key: "$clusterTime", Value: bson.D{{Key: "clusterTime", Value: bsonprim.Timestamp{T: 1}}}},
+		{Key: "operationTime", Value: bsonprim.Timestamp{T: 1}},
+	}
+
+	DescribeTable("should extract",
+		func(path string, expected []bson.D, found bool) {
+			docs, err := plugin.GetCommandResultDocuments(reply, path)
+			if !found {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(docs).To(Equal(expected))
+		},
+		Entry("the reply without its metadata for an empty path", "",
+			[]bson.D{{{Key: "db", Value: "my_db"}, {Key: "collections", Value: int32(2)}, reply[2]}},
+			true,
+		),
+		Entry("each document of an array", "cursor.firstBatch",
+			[]bson.D{{{Key: "name", Value: "a"}}, {{Key: "name", Value: "b"}}},
+			true,
+		),
+		Entry("a scalar under the last element of its path", "collections",
+			[]bson.D{{{Key: "collections", Value: int32(2)}}},
+			true,
+		),
+		Entry("nothing for a missing path", "cursor.nextBatch", nil, false),
+	)
+})
@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"context"
+	"math"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// countFieldName is the name of the only field returned by a count query
+const countFieldName = "count"
+
+// runCount counts the documents matching the filter, producing a single document with the count.
+// If there is no filter, the much cheaper estimatedDocumentCount is used instead.
+func (m *QueryModel) runCount(ctx context.Context, collection *mongo.Collection, r queryRange) (documentSource, error) {
+	filter, err := m.getFilter(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to produce final filter")
+	}
+	var count int64
+	if len(filter) == 0 {
+		log.DefaultLogger.Debug("Estimating document count")
+		count, err = collection.EstimatedDocumentCount(ctx)
+	} else {
+		log.DefaultLogger.Debug("Counting documents", "filter", filter)
+		count, err = collection.CountDocuments(ctx, filter)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to send query to mongo")
+	}
+	return newSliceSource([]bson.D{{bson.E{Key: countFieldName, Value: count}}}), nil
+}
+
+// runDistinct finds the distinct values of a field among the documents matching the filter,
+// producing one document per value
+func (m *QueryModel) runDistinct(ctx context.Context, collection *mongo.Collection, r queryRange) (documentSource, error) {
+	if m.DistinctField == "" {
+		return nil, errors.New("Distinct Field must be specified")
+	}
+	filter, err := m.getFilter(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to produce final filter")
+	}
+	log.DefaultLogger.Debug("Finding distinct values", "field", m.DistinctField, "filter", filter)
+	values, err := collection.Distinct(ctx, m.DistinctField, filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to send query to mongo")
+	}
+	docs := make([]bson.D, len(values))
+	for ix, value := range values {
+		docs[ix] = bson.D{bson.E{Key: m.DistinctField, Value: value}}
+	}
+	return newSliceSource(docs), nil
+}
+
+// getCountFields returns the fixed schema of a count query
+func getCountFields() []field {
+	return []field{{Name: countFieldName, Type: data.FieldTypeInt64}}
+}
+
+// usesSchemaInference returns true if the schema of the results must be inferred.
+// The types of a distinct field, or of a command reply, are unknown, so they are always inferred.
+// Other query types with a fixed schema never use it.
+func (m *QueryModel) usesSchemaInference() bool {
+	switch m.QueryType {
+	case queryTypeDistinct, queryTypeCommand:
+		return true
+	}
+	return m.SchemaInference && !m.hasFixedSchema()
+}
+
+// getSchemaInferenceDepth returns how many documents to infer the schema from.
+// Distinct values and non-cursor command replies are already in memory, so all of them are used.
+func (m *QueryModel) getSchemaInferenceDepth() int {
+	if m.QueryType == queryTypeDistinct || (m.QueryType == queryTypeCommand && !m.CommandCursor) {
+		return math.MaxInt32
+	}
+	return m.SchemaInferenceDepth
+}
+
+// usesFallbackToString returns true if fields with incompatible types should be inferred as strings.
+// Distinct values of a single field often have different types, and are usually used for variables,
+// which are always strings, so they always fall back.
+func (m *QueryModel) usesFallbackToString() bool {
+	return m.SchemaInferenceFallbackToString || m.QueryType == queryTypeDistinct
+}
@@ -0,0 +1,131 @@
+package plugin
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// redactedValue replaces literal values in redacted commands
+const redactedValue = "?"
+
+// getCurrentOpFields returns the fixed schema of a current operations query
+func getCurrentOpFields() []field {
+	return []field{
+		{Name: "opid", Type: data.FieldTypeString},
+		{Name: "ns", Type: data.FieldTypeNullableString},
+		{Name: "op", Type: data.FieldTypeNullableString},
+		{Name: "client", Type: data.FieldTypeNullableString},
+		{Name: "secs_running", Type: data.FieldTypeNullableInt64},
+		{Name: "planSummary", Type: data.FieldTypeNullableString},
+		{Name: "command", Type: data.FieldTypeNullableJSON},
+	}
+}
+
+// getCurrentOpPipeline produces a pipeline for the admin database which lists the active operations
+// matching the filters, longest running first
+func (m *QueryModel) getCurrentOpPipeline() mongo.Pipeline {
+	match := bson.D{bson.E{Key: "active", Value: true}}
+	if m.CurrentOpMinSecsRunning > 0 {
+		match = append(match, bson.E{Key: "secs_running", Value: bson.D{bson.E{Key: "$gte", Value: m.CurrentOpMinSecsRunning}}})
+	}
+	if m.CurrentOpNamespace != "" {
+		if strings.Contains(m.CurrentOpNamespace, ".") {
+			match = append(match, bson.E{Key: "ns", Value: m.CurrentOpNamespace})
+		} else {
+			// A namespace without a collection matches every collection in that database
+			match = append(match, bson.E{Key: "ns", Value: bsonPrim.Regex{Pattern: "^" + regexp.QuoteMeta(m.CurrentOpNamespace) + `\.`}})
+		}
+	}
+	if m.CurrentOpType != "" {
+		match = append(match, bson.E{Key: "op", Value: m.CurrentOpType})
+	}
+
+	return mongo.Pipeline{
+		{bson.E{Key: "$currentOp", Value: bson.D{bson.E{Key: "allUsers", Value: true}}}},
+		{bson.E{Key: "$match", Value: match}},
+		{bson.E{Key: "$sort", Value: bson.D{bson.E{Key: "secs_running", Value: -1}}}},
+		{bson.E{Key: "$project", Value: bson.D{
+			bson.E{Key: "_id", Value: 0},
+			// opid is a number on mongod, but a string of the form shard:opid on mongos
+			bson.E{Key: "opid", Value: bson.D{bson.E{Key: "$toString", Value: "$opid"}}},
+			bson.E{Key: "ns", Value: 1},
+			bson.E{Key: "op", Value: 1},
+			// mongos reports the client address as client_s
+			bson.E{Key: "client", Value: bson.D{bson.E{Key: "$ifNull", Value: bson.A{"$client", "$client_s"}}}},
+			bson.E{Key: "secs_running", Value: 1},
+			bson.E{Key: "planSummary", Value: 1},
+			bson.E{Key: "command", Value: 1},
+		}}},
+	}
+}
+
+// runCurrentOp lists the active operations on the server, with the literal values in their commands redacted
+func (m *QueryModel) runCurrentOp(ctx context.Context, client *mongo.Client) (documentSource, error) {
+	pipeline := m.getCurrentOpPipeline()
+	log.DefaultLogger.Debug("Effective pipeline", "pipeline", pipeline)
+	cursor, err := client.Database("admin").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to send query to mongo")
+	}
+	docs := make([]bson.D, 0)
+	err = cursor.All(ctx, &docs)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to fetch current operations")
+	}
+	for _, doc := range docs {
+		for ix := range doc {
+			if doc[ix].Key != "command" {
+				continue
+			}
+			if command, isDoc := doc[ix].Value.(bson.D); isDoc {
+				doc[ix].Value = redactCommand(command)
+			}
+		}
+	}
+	return newSliceSource(docs), nil
+}
+
+// redactCommand replaces the literal values in a command with placeholders, keeping its shape.
+// The first element names the command and usually its collection, so it is kept as-is if it is a string,
+// as are elements which do not contain user data, like $db.
+func redactCommand(command bson.D) bson.D {
+	redacted := make(bson.D, len(command))
+	for ix, elem := range command {
+		_, isName := elem.Value.(string)
+		if (ix == 0 && isName) || elem.Key == "$db" {
+			redacted[ix] = elem
+			continue
+		}
+		redacted[ix] = bson.E{Key: elem.Key, Value: redactLiterals(elem.Value)}
+	}
+	return redacted
+}
+
+// redactLiterals replaces every scalar value within a value with a placeholder.
+// Documents and arrays keep their keys and lengths, so that values with the same shape redact identically.
+func redactLiterals(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		redacted := make(bson.D, len(v))
+		for ix, elem := range v {
+			redacted[ix] = bson.E{Key: elem.Key, Value: redactLiterals(elem.Value)}
+		}
+		return redacted
+	case bson.A:
+		redacted := make(bson.A, len(v))
+		for ix, elem := range v {
+			redacted[ix] = redactLiterals(elem)
+		}
+		return redacted
+	default:
+		return redactedValue
+	}
+}
@@ -0,0 +1,45 @@
+package plugin_test
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RedactCommand", func() {
+	DescribeTable("should redact",
+		func(command bson.D, expected bson.D) {
+			Expect(plugin.RedactCommand(command)).To(Equal(expected))
+		},
+		Entry("the literals of a filter, keeping the command name and database",
+			bson.D{
+				{Key: "find", Value: "users"},
+				{Key: "filter", Value: bson.D{{Key: "email", Value: "a@example.com"}, {Key: "age", Value: bson.D{{Key: "$gt", Value: int32(30)}}}}},
+				{Key: "$db", Value: "app"},
+			},
+			bson.D{
+				{Key: "find", Value: "users"},
+				{Key: "filter", Value: bson.D{{Key: "email", Value: "?"}, {Key: "age", Value: bson.D{{Key: "$gt", Value: "?"}}}}},
+				{Key: "$db", Value: "app"},
+			},
+		),
+		Entry("every element of an array, keeping its length",
+			bson.D{
+				{Key: "insert", Value: "users"},
+				{Key: "documents", Value: bson.A{bson.D{{Key: "name", Value: "a"}}, bson.D{{Key: "name", Value: "b"}}}},
+			},
+			bson.D{
+				{Key: "insert", Value: "users"},
+				{Key: "documents", Value: bson.A{bson.D{{Key: "name", Value: "?"}}, bson.D{{Key: "name", Value: "?"}}}},
+			},
+		),
+		Entry("a first element which is not a name",
+			bson.D{{Key: "getMore", Value: int64(12345)}, {Key: "collection", Value: "users"}},
+			bson.D{{Key: "getMore", Value: "?"}, {Key: "collection", Value: "?"}},
+		),
+		Entry("nothing in an empty command", bson.D{}, bson.D{}),
+	)
+})
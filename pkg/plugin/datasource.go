@@ -3,9 +3,11 @@ package plugin
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"net/url"
 
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/pkg/errors"
 )
 
@@ -16,8 +18,13 @@ type jsonData struct {
 	TLSCA          string `json:"tlsCa"`
 	TLSInsecure    bool   `json:"tlsInsecure"`
 	TLSServerName  string `json:"tlsServerName"`
+	// MaxConcurrentQueries is the number of queries from a single request which may execute at once.
+	// Zero or less means defaultMaxConcurrentQueries
+	MaxConcurrentQueries int `json:"maxConcurrentQueries,omitempty"`
 }
 
+const defaultMaxConcurrentQueries = 4
+
 type secureJsonData struct {
 	Username          string `json:"username"`
 	Password          string `json:"password"`
@@ -29,6 +36,30 @@ type datasource struct {
 	secureJsonData
 }
 
+func parseDatasource(settings backend.DataSourceInstanceSettings) (datasource, error) {
+	data := datasource{}
+	err := json.Unmarshal([]byte(settings.JSONData), &data.jsonData)
+	if err != nil {
+		return datasource{}, errors.Wrap(err, "Failed to parse data source settings")
+	}
+	secureJsonData, err := json.Marshal(settings.DecryptedSecureJSONData)
+	if err != nil {
+		return datasource{}, errors.Wrap(err, "Failed to remarshal secure data source settings")
+	}
+	err = json.Unmarshal(secureJsonData, &data.secureJsonData)
+	if err != nil {
+		return datasource{}, errors.Wrap(err, "Failed to parse data source settings")
+	}
+	return data, nil
+}
+
+func (d *datasource) getMaxConcurrentQueries() int {
+	if d.MaxConcurrentQueries <= 0 {
+		return defaultMaxConcurrentQueries
+	}
+	return d.MaxConcurrentQueries
+}
+
 func (d *datasource) applyAuth(uri *url.URL) error {
 	if d.Username == "" {
 		return nil
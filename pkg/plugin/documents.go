@@ -2,12 +2,18 @@ package plugin
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -24,15 +30,193 @@ type field struct {
 }
 
 func (f *field) get(doc timestepDocument) interface{} {
-	return doc[f.Name]
+	value, _ := lookupPath(doc, f.Name)
+	return value
+}
+
+// lookupPath resolves a field path against a document. A path is either a top-level key, or a
+// dot-separated path through embedded documents, where array elements are referenced by index,
+// e.g. metadata.sensorId or readings.0.value
+func lookupPath(doc timestepDocument, path string) (interface{}, bool) {
+	// Keys may contain dots themselves, so an exact match takes precedence
+	if value, ok := doc[path]; ok {
+		return value, true
+	}
+	if !strings.Contains(path, ".") {
+		return nil, false
+	}
+	var current interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		next, ok := lookupPathElement(current, key)
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	return current, true
+}
+
+// lookupText converts the value of a field to a string, or nil if it is not configured or absent.
+// Embedded documents and arrays are converted to JSON
+func lookupText(doc timestepDocument, fieldName string) (*string, error) {
+	if fieldName == "" {
+		return nil, nil
+	}
+	value, ok := lookupPath(doc, fieldName)
+	if !ok || value == nil {
+		return nil, nil
+	}
+	converted, actualType, err := ToGrafanaValue(value)
+	if err != nil {
+		return nil, err
+	}
+	if actualType == data.FieldTypeJSON {
+		text := string(converted.(json.RawMessage))
+		return &text, nil
+	}
+	text, err := convertValue(value, data.FieldTypeNullableString, true)
+	if err != nil {
+		return nil, err
+	}
+	return text.(*string), nil
+}
+
+func lookupPathElement(value interface{}, key string) (interface{}, bool) {
+	switch v := value.(type) {
+	case bson.D:
+		for _, elem := range v {
+			if elem.Key == key {
+				return elem.Value, true
+			}
+		}
+	case bson.M:
+		elem, ok := v[key]
+		return elem, ok
+	case map[string]interface{}:
+		elem, ok := v[key]
+		return elem, ok
+	case bson.A:
+		return lookupPathIndex(v, key)
+	case []interface{}:
+		return lookupPathIndex(v, key)
+	}
+	return nil, false
+}
+
+func lookupPathIndex(array []interface{}, key string) (interface{}, bool) {
+	ix, err := strconv.Atoi(key)
+	if err != nil || ix < 0 || ix >= len(array) {
+		return nil, false
+	}
+	return array[ix], true
 }
 
 type resultParser struct {
 	frames map[string]*data.Frame
-	model  resolvedQueryModel
+	// frameOrder contains the keys of frames in the order they were created
+	frameOrder []string
+	model      resolvedQueryModel
+	// reviseSchema, if set, allows the schema to be revised when a document does not match it
+	reviseSchema bool
+	ignored      map[string]struct{}
+	// known contains the names of the fields of the schema, while schema revision is enabled
+	known map[string]struct{}
+	// fallbackToString allows revised fields to be widened to strings or JSON, see widenType
+	fallbackToString bool
+	// docCount is the number of source documents parsed, before any are expanded into multiple rows
+	docCount int
+	// firstRevision is the (1-indexed) number of the first document which caused a schema revision, or zero if none
+	firstRevision int
+	// expander, if set, expands each document into multiple rows
+	expander *arrayExpander
+}
+
+// enableSchemaRevision allows the parser to add new fields, make existing fields nullable, and widen
+// their types, when a document is encountered which does not match the schema, instead of failing.
+// Fields in ignored are never added.
+func (p *resultParser) enableSchemaRevision(ignored map[string]struct{}, fallbackToString bool) {
+	p.reviseSchema = true
+	p.ignored = ignored
+	p.fallbackToString = fallbackToString
+	fields, _ := p.model.getFields()
+	p.known = make(map[string]struct{}, len(fields))
+	for _, f := range fields {
+		p.known[f.Name] = struct{}{}
+	}
+}
+
+func newResultParser(model resolvedQueryModel) resultParser {
+	return resultParser{
+		frames:     map[string]*data.Frame{},
+		frameOrder: make([]string, 0),
+		model:      model,
+	}
+}
+
+// getFrames returns the parsed frames in the order their label combinations were first seen,
+// or, if sortByLabels is set, ordered by their label values
+func (p *resultParser) getFrames(sortByLabels bool) []*data.Frame {
+	frames := make([]*data.Frame, 0, len(p.frameOrder))
+	for _, id := range p.frameOrder {
+		frames = append(frames, p.frames[id])
+	}
+	if sortByLabels {
+		sort.SliceStable(frames, func(i, j int) bool {
+			return compareFrameLabels(frames[i], frames[j]) < 0
+		})
+	}
+	return frames
+}
+
+func frameLabels(frame *data.Frame) data.Labels {
+	for _, field := range frame.Fields {
+		if field.Labels != nil {
+			return field.Labels
+		}
+	}
+	return data.Labels{}
+}
+
+// compareFrameLabels compares two frames by their label values, sorted by label name.
+// See compareLabelValues for how values are compared
+func compareFrameLabels(a, b *data.Frame) int {
+	aLabels := frameLabels(a)
+	bLabels := frameLabels(b)
+	names := make([]string, 0, len(aLabels)+len(bLabels))
+	for name := range aLabels {
+		names = append(names, name)
+	}
+	for name := range bLabels {
+		if _, ok := aLabels[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if cmp := compareLabelValues(aLabels[name], bLabels[name]); cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// compareLabelValues compares two label values as numbers if both are numbers, so that "9" comes before "10",
+// and as strings otherwise
+func compareLabelValues(a, b string) int {
+	aNumber, aErr := strconv.ParseFloat(a, 64)
+	bNumber, bErr := strconv.ParseFloat(b, 64)
+	if aErr != nil || bErr != nil || math.IsNaN(aNumber) || math.IsNaN(bNumber) || aNumber == bNumber {
+		return strings.Compare(a, b)
+	}
+	if aNumber < bNumber {
+		return -1
+	}
+	return 1
 }
 
-func (p *resultParser) parseQueryResultDocument(doc timestepDocument) (err error) {
+// parseQueryResultDocument parses a result document, given its keys in document order,
+// into one row, or one row per array element if an expander is set
+func (p *resultParser) parseQueryResultDocument(doc timestepDocument, keys []string) (err error) {
 	defer func() {
 		if panic_ := recover(); panic_ != nil {
 			buf := make([]byte, 1<<16)
@@ -46,6 +230,27 @@ func (p *resultParser) parseQueryResultDocument(doc timestepDocument) (err error
 			}
 		}
 	}()
+	p.docCount++
+	if p.expander == nil {
+		return p.parseRow(doc, keys)
+	}
+	rows, rowKeys := p.expander.expand(doc, keys)
+	for ix, row := range rows {
+		err = p.parseRow(row, rowKeys[ix])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *resultParser) parseRow(doc timestepDocument, keys []string) (err error) {
+	if p.reviseSchema {
+		err = p.reviseSchemaFor(doc, keys)
+		if err != nil {
+			return errors.Wrap(err, "Failed to revise schema")
+		}
+	}
 	labels, labelsID := p.model.getLabels(doc)
 	frame, ok := p.frames[labelsID]
 	if !ok {
@@ -55,6 +260,7 @@ func (p *resultParser) parseQueryResultDocument(doc timestepDocument) (err error
 			return err
 		}
 		p.frames[labelsID] = frame
+		p.frameOrder = append(p.frameOrder, labelsID)
 	}
 	row, err := p.model.getValues(doc)
 	if err != nil {
@@ -66,38 +272,368 @@ func (p *resultParser) parseQueryResultDocument(doc timestepDocument) (err error
 	return nil
 }
 
+// decodeDocument decodes the current document of a cursor, returning it along with
+// its top-level keys in the order they appeared.
+// Documents are decoded as ordered bson.D so that embedded documents also keep their key order.
+// If flattenDepth is non-zero, embedded documents are flattened, see flattenDocument.
+func decodeDocument(cursor documentSource, flattenDepth int) (doc timestepDocument, keys []string, err error) {
+	ordered := bson.D{}
+	err = cursor.Decode(&ordered)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, keys = flattenDocument(ordered, flattenDepth)
+	return doc, keys, nil
+}
+
+// flattenDocument converts an ordered document to a timestepDocument, returning its keys in order.
+// Embedded documents up to flattenDepth levels deep are expanded into keys of the form parent.child,
+// so that each of their fields becomes its own column. Embedded documents deeper than that,
+// along with arrays, are left intact.
+func flattenDocument(ordered bson.D, flattenDepth int) (timestepDocument, []string) {
+	doc := make(timestepDocument, len(ordered))
+	keys := make([]string, 0, len(ordered))
+	flattenInto(doc, &keys, "", ordered, flattenDepth)
+	return doc, keys
+}
+
+func flattenInto(doc timestepDocument, keys *[]string, prefix string, ordered bson.D, flattenDepth int) {
+	for _, elem := range ordered {
+		key := prefix + elem.Key
+		if embedded, isDoc := elem.Value.(bson.D); isDoc && flattenDepth > 0 {
+			flattenInto(doc, keys, key+".", embedded, flattenDepth-1)
+			continue
+		}
+		if _, dup := doc[key]; !dup {
+			*keys = append(*keys, key)
+		}
+		doc[key] = elem.Value
+	}
+}
+
+// reviseSchemaFor revises the schema to accommodate a document, given its keys in document order.
+// Non-nullable fields which are absent are made nullable, fields which appear with a different type are
+// widened, and new fields are added as nullable, in the order they appear in the document.
+// Frames which have already been created are converted to match, with new fields being backfilled with nulls.
+func (p *resultParser) reviseSchemaFor(doc timestepDocument, keys []string) error {
+	fields, offset := p.model.getFields()
+	revised := false
+	for ix, f := range fields {
+		value := f.get(doc)
+		if value == nil {
+			if f.Type.Nullable() {
+				continue
+			}
+			log.DefaultLogger.Debug("Field absent after schema inference, making type nullable", "name", f.Name)
+			fields[ix].Type = f.Type.NullableType()
+			for _, frame := range p.frames {
+				frame.Fields[offset+ix] = makeFieldNullable(frame.Fields[offset+ix])
+			}
+			revised = true
+			continue
+		}
+		_, type_, err := ToGrafanaValue(value)
+		if err != nil {
+			return err
+		}
+		current := f.Type.NonNullableType()
+		widened, ok := widenType(current, type_, p.fallbackToString)
+		if !ok || widened == current {
+			// Values which cannot be widened to are reported when the row is converted
+			continue
+		}
+		if f.Type.Nullable() {
+			widened = widened.NullableType()
+		}
+		log.DefaultLogger.Debug("Field appeared with a different type after schema inference, widening", "name", f.Name, "old", f.Type, "new", widened)
+		fields[ix].Type = widened
+		for _, frame := range p.frames {
+			frame.Fields[offset+ix], err = widenField(frame.Fields[offset+ix], widened)
+			if err != nil {
+				return err
+			}
+		}
+		revised = true
+	}
+
+	for _, name := range keys {
+		if _, ok := p.known[name]; ok {
+			continue
+		}
+		if _, ok := p.ignored[name]; ok {
+			continue
+		}
+		_, type_, err := ToGrafanaValue(doc[name])
+		if err != nil {
+			return err
+		}
+		// Like schema inference, a null value is treated as the field being absent
+		if type_ == data.FieldTypeUnknown {
+			continue
+		}
+		newField := field{Name: name, Type: type_.NullableType()}
+		log.DefaultLogger.Debug("New field discovered after schema inference, adding it", "name", name, "type", newField.Type)
+		for _, frame := range p.frames {
+			frameField, err := p.model.makeField(newField, frameLabels(frame), frame.Rows())
+			if err != nil {
+				return err
+			}
+			frame.Fields = append(frame.Fields, frameField)
+		}
+		fields = append(fields, newField)
+		p.known[name] = struct{}{}
+		revised = true
+	}
+
+	if revised {
+		p.model.setFields(fields)
+		if p.firstRevision == 0 {
+			p.firstRevision = p.docCount
+		}
+	}
+	return nil
+}
+
+// makeFieldNullable copies a non-nullable field into a field of the equivalent nullable type
+func makeFieldNullable(f *data.Field) *data.Field {
+	nullable := data.NewFieldFromFieldType(f.Type().NullableType(), f.Len())
+	nullable.Name = f.Name
+	nullable.Labels = f.Labels
+	nullable.Config = f.Config
+	for ix := 0; ix < f.Len(); ix++ {
+		nullable.Set(ix, toPointer(f.At(ix)))
+	}
+	return nullable
+}
+
+// widenField copies a field into a field of a wider type, as chosen by widenType
+func widenField(f *data.Field, to data.FieldType) (*data.Field, error) {
+	widened := data.NewFieldFromFieldType(to, f.Len())
+	widened.Name = f.Name
+	widened.Labels = f.Labels
+	widened.Config = f.Config
+	from := f.Type().NonNullableType()
+	for ix := 0; ix < f.Len(); ix++ {
+		value, ok := f.ConcreteAt(ix)
+		if !ok {
+			continue
+		}
+		value, err := widenValue(value, from, to.NonNullableType())
+		if err != nil {
+			return nil, err
+		}
+		if to.Nullable() {
+			value = toPointer(value)
+		}
+		widened.Set(ix, value)
+	}
+	return widened, nil
+}
+
+// arrayExpander expands documents following the bucket pattern, where a single document contains an
+// array of samples, into one row per array element. Each row contains the fields of the parent
+// document, except for the array itself, along with the fields of the element. If the elements are not
+// documents, the element value is stored under the name of the array field instead.
+// Documents where the array field is absent or not an array are left as a single row, unchanged.
+type arrayExpander struct {
+	field        string
+	flattenDepth int
+}
+
+// expand expands a document into rows. If keys are provided, the keys of each row are returned
+// in order as well, with the keys of the element following the key which contained the array
+func (e *arrayExpander) expand(doc timestepDocument, keys []string) ([]timestepDocument, [][]string) {
+	value, _ := lookupPath(doc, e.field)
+	var elems []interface{}
+	switch array := value.(type) {
+	case bson.A:
+		elems = array
+	case []interface{}:
+		elems = array
+	default:
+		return []timestepDocument{doc}, [][]string{keys}
+	}
+
+	// The array is either a key of its own, or is nested within the embedded document of a key
+	parentKey, parentValue := e.field, interface{}(nil)
+	if _, isKey := doc[e.field]; !isKey {
+		for key := range doc {
+			if strings.HasPrefix(e.field, key+".") && len(key) < len(parentKey) {
+				parentKey = key
+			}
+		}
+		parentValue = withoutPath(doc[parentKey], strings.Split(e.field[len(parentKey)+1:], "."))
+	}
+
+	rows := make([]timestepDocument, len(elems))
+	var rowKeys [][]string
+	if keys != nil {
+		rowKeys = make([][]string, len(elems))
+	}
+	for ix, elem := range elems {
+		var elemDoc timestepDocument
+		var elemKeys []string
+		if ordered, isDoc := elem.(bson.D); isDoc {
+			elemDoc, elemKeys = flattenDocument(ordered, e.flattenDepth)
+		} else {
+			elemDoc = timestepDocument{e.field: elem}
+			elemKeys = []string{e.field}
+		}
+
+		row := make(timestepDocument, len(doc)+len(elemDoc))
+		for key, value := range doc {
+			if key != parentKey {
+				row[key] = value
+			} else if parentValue != nil {
+				row[key] = parentValue
+			}
+		}
+		for key, value := range elemDoc {
+			row[key] = value
+		}
+		rows[ix] = row
+
+		if keys == nil {
+			continue
+		}
+		rowKeys[ix] = make([]string, 0, len(keys)+len(elemKeys))
+		for _, key := range keys {
+			if key == parentKey {
+				if parentValue != nil {
+					rowKeys[ix] = append(rowKeys[ix], key)
+				}
+				rowKeys[ix] = append(rowKeys[ix], elemKeys...)
+				continue
+			}
+			if _, overridden := elemDoc[key]; !overridden {
+				rowKeys[ix] = append(rowKeys[ix], key)
+			}
+		}
+	}
+	return rows, rowKeys
+}
+
+// withoutPath returns a copy of an embedded document with the field at a path removed from it.
+// Values which are not embedded documents are returned as-is
+func withoutPath(value interface{}, path []string) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		copied := make(bson.D, 0, len(v))
+		for _, elem := range v {
+			if elem.Key != path[0] {
+				copied = append(copied, elem)
+			} else if len(path) > 1 {
+				copied = append(copied, bson.E{Key: elem.Key, Value: withoutPath(elem.Value, path[1:])})
+			}
+		}
+		return copied
+	case bson.M:
+		return bson.M(withoutPathMap(v, path))
+	case map[string]interface{}:
+		return withoutPathMap(v, path)
+	}
+	return value
+}
+
+func withoutPathMap(v map[string]interface{}, path []string) map[string]interface{} {
+	copied := make(map[string]interface{}, len(v))
+	for key, elem := range v {
+		if key != path[0] {
+			copied[key] = elem
+		} else if len(path) > 1 {
+			copied[key] = withoutPath(elem, path[1:])
+		}
+	}
+	return copied
+}
+
+// documentSource produces result documents, e.g. a *mongo.Cursor, or a sliceSource
+type documentSource interface {
+	Next(ctx context.Context) bool
+	Decode(val interface{}) error
+	Err() error
+}
+
+var _ = documentSource(&mongo.Cursor{})
+
+// sliceSource is a documentSource for documents which have already been fetched,
+// such as the result of a command which does not return a cursor
+type sliceSource struct {
+	docs    []bson.D
+	current bson.D
+}
+
+func newSliceSource(docs []bson.D) *sliceSource {
+	return &sliceSource{docs: docs}
+}
+
+func (s *sliceSource) Next(ctx context.Context) bool {
+	if len(s.docs) == 0 {
+		return false
+	}
+	s.current = s.docs[0]
+	s.docs = s.docs[1:]
+	return true
+}
+
+func (s *sliceSource) Decode(val interface{}) error {
+	if ordered, ok := val.(*bson.D); ok {
+		*ordered = s.current
+		return nil
+	}
+	bytes, err := bson.Marshal(s.current)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(bytes, val)
+}
+
+func (s *sliceSource) Err() error {
+	return nil
+}
+
+var _ = documentSource(&sliceSource{})
+
+// bufferedDocument is a decoded document, along with its keys in document order
+type bufferedDocument struct {
+	doc  timestepDocument
+	keys []string
+}
+
 type bufferingCursor struct {
-	*mongo.Cursor
-	buffer []timestepDocument
+	Cursor       documentSource
+	buffer       []bufferedDocument
+	flattenDepth int
 }
 
-func (c *bufferingCursor) Next(ctx context.Context) (doc timestepDocument, more bool, err error) {
+func (c *bufferingCursor) Next(ctx context.Context) (doc timestepDocument, keys []string, more bool, err error) {
 	more = c.Cursor.Next(ctx)
 	if !more {
 		err = c.Cursor.Err()
 		return
 	}
 
-	doc = make(timestepDocument)
-	err = c.Cursor.Decode(&doc)
+	doc, keys, err = decodeDocument(c.Cursor, c.flattenDepth)
 	if err != nil {
 		more = false
 		return
 	}
 
-	c.buffer = append(c.buffer, doc)
+	c.buffer = append(c.buffer, bufferedDocument{doc: doc, keys: keys})
 	more = true
 	return
 }
 
 type bufferedCursor struct {
-	*mongo.Cursor
-	buffer []timestepDocument
+	Cursor       documentSource
+	buffer       []bufferedDocument
+	flattenDepth int
 }
 
-func (c *bufferedCursor) Next(ctx context.Context) (doc timestepDocument, more bool, decodeErr bool, err error) {
+func (c *bufferedCursor) Next(ctx context.Context) (doc timestepDocument, keys []string, more bool, decodeErr bool, err error) {
 	if len(c.buffer) != 0 {
-		doc = c.buffer[0]
+		doc = c.buffer[0].doc
+		keys = c.buffer[0].keys
 		c.buffer = c.buffer[1:]
 		more = true
 		return
@@ -109,8 +645,7 @@ func (c *bufferedCursor) Next(ctx context.Context) (doc timestepDocument, more b
 		return
 	}
 
-	doc = make(timestepDocument)
-	err = c.Decode(&doc)
+	doc, keys, err = decodeDocument(c.Cursor, c.flattenDepth)
 	if err != nil {
 		decodeErr = true
 		more = false
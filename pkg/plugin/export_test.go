@@ -0,0 +1,196 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Unexported helpers which are pure functions are exported here so that they can be tested directly
+var (
+	LookupPath                 = lookupPath
+	WidenType                  = widenType
+	ConvertValue               = convertValue
+	FlattenDocument            = flattenDocument
+	GetCommandResultDocuments  = getCommandResultDocuments
+	RedactCommand              = redactCommand
+	GetBSONTimestampBoundMatch = getBSONTimestampBoundMatch
+	GetBucketStart             = getBucketStart
+	GetNewerRows               = getNewerRows
+	GetTopProfileShapes        = getTopProfileShapes
+	ErrDisposed                = errDisposed
+)
+
+// ExpandArray expands a document into one row per element of the array at a path, see arrayExpander
+func ExpandArray(field string, doc map[string]interface{}, keys []string) ([]map[string]interface{}, [][]string) {
+	expander := arrayExpander{field: field}
+	return expander.expand(doc, keys)
+}
+
+// ReadDocuments converts documents to frames in the same way as the results of a query
+func ReadDocuments(qm *QueryModel, docs []bson.D) backend.DataResponse {
+	return readCursor(context.Background(), qm, newSliceSource(docs))
+}
+
+// ExpandMacros expands the macros in a value from a pipeline for a time range, interval and number of data points
+func ExpandMacros(qm *QueryModel, value interface{}, from, to time.Time, interval time.Duration, maxDataPoints int64) (interface{}, error) {
+	return qm.expandMacros(value, queryRange{From: from, To: to, Interval: interval, MaxDataPoints: maxDataPoints})
+}
+
+// GetBucketInterval determines the width of automatic time buckets for a time range, interval and number of data points
+func GetBucketInterval(from, to time.Time, interval time.Duration, maxDataPoints int64) time.Duration {
+	return getBucketInterval(queryRange{From: from, To: to, Interval: interval, MaxDataPoints: maxDataPoints})
+}
+
+// GetCommand parses and checks the command of a Command query
+func GetCommand(qm *QueryModel) (bson.D, error) {
+	return qm.getCommand(queryRange{})
+}
+
+// ApplyServerStatusRates converts the counters of consecutive frames of serverStatus samples to rates
+func ApplyServerStatusRates(frames ...*data.Frame) error {
+	rates := newServerStatusRates(serverStatusMetrics)
+	for _, frame := range frames {
+		err := rates.apply(frame)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetProfileShape normalizes the command, or the query of an older server, of a profiled operation
+func GetProfileShape(command, query bson.D) (string, error) {
+	entry := profileEntry{Command: command, Query: query}
+	return entry.getShape()
+}
+
+// ProfileShape is the totals for all of the profiled operations with the same shape
+type ProfileShape = profileShape
+
+// NewProfileShape produces the totals of a shape, see profileShape
+func NewProfileShape(shape string, count, millis, docsExamined, keysExamined int64) *ProfileShape {
+	return &profileShape{
+		shape:        shape,
+		count:        count,
+		millis:       millis,
+		docsExamined: docsExamined,
+		keysExamined: keysExamined,
+	}
+}
+
+// SkipTailed feeds the _ids of the documents returned by a newly opened tailable cursor to a tailSkipper for
+// a stream at a position, and returns which of them were skipped, and whether the last document read was missed.
+// The cursor catches up after the first caughtUpAfter documents
+func SkipTailed(started bool, lastID interface{}, ids []interface{}, caughtUpAfter int) ([]bool, bool, error) {
+	position := tailPosition{started: started}
+	var err error
+	if lastID != nil {
+		position.lastID, err = rawValue(lastID)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	skipper := newTailSkipper(position)
+	skipped := make([]bool, len(ids))
+	missed := false
+	for ix, id := range ids {
+		if ix == caughtUpAfter {
+			missed = skipper.caughtUp()
+		}
+		value, err := rawValue(id)
+		if err != nil {
+			return nil, false, err
+		}
+		skipped[ix] = skipper.read(value)
+	}
+	if caughtUpAfter >= len(ids) {
+		missed = skipper.caughtUp()
+	}
+	return skipped, missed, nil
+}
+
+// rawValue marshals a value in the same way as it is read from a document
+func rawValue(value interface{}) (bson.RawValue, error) {
+	type_, data, err := bson.MarshalValue(value)
+	return bson.RawValue{Type: type_, Value: data}, err
+}
+
+// RunQueries executes queries concurrently with a function in the same way as QueryData
+var RunQueries = runQueries
+
+// decodeReplSetStatus decodes a reply to replSetGetStatus in the same way as getReplSetStatus
+func decodeReplSetStatus(reply bson.D) (*replSetStatus, error) {
+	status := replSetStatus{}
+	err := unmarshalDocument(reply, &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// GetReplSetLags returns the lag of each member of a reply to replSetGetStatus which has one, by name
+func GetReplSetLags(reply bson.D) (map[string]time.Duration, error) {
+	status, err := decodeReplSetStatus(reply)
+	if err != nil {
+		return nil, err
+	}
+	lags := make(map[string]time.Duration, len(status.Members))
+	for _, member := range status.Members {
+		if lag, ok := status.lag(member); ok {
+			lags[member.Name] = lag
+		}
+	}
+	return lags, nil
+}
+
+// GetReplSetFrames produces the members and lag frames of a reply to replSetGetStatus
+func GetReplSetFrames(reply bson.D) (*data.Frame, *data.Frame, error) {
+	status, err := decodeReplSetStatus(reply)
+	if err != nil {
+		return nil, nil, err
+	}
+	return status.membersFrame(), status.lagFrame(), nil
+}
+
+// GetIndexStatsRows decodes the replies of listIndexes, $indexStats and $collStats for a collection
+// in the same way as getIndexStats, and merges them into rows
+func GetIndexStatsRows(collection string, specs, stats, collStats []bson.D) ([]bson.D, error) {
+	var err error
+	decodedSpecs := make([]*mongo.IndexSpecification, len(specs))
+	for ix, spec := range specs {
+		decodedSpecs[ix] = &mongo.IndexSpecification{}
+		err = unmarshalDocument(spec, decodedSpecs[ix])
+		if err != nil {
+			return nil, err
+		}
+	}
+	decodedStats := make([]indexStat, len(stats))
+	for ix, stat := range stats {
+		err = unmarshalDocument(stat, &decodedStats[ix])
+		if err != nil {
+			return nil, err
+		}
+	}
+	decodedCollStats := make([]collStatsShard, len(collStats))
+	for ix, shard := range collStats {
+		err = unmarshalDocument(shard, &decodedCollStats[ix])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return getIndexStatsRows(collection, decodedSpecs, decodedStats, decodedCollStats)
+}
+
+// unmarshalDocument decodes a document into a value in the same way as a cursor or command reply
+func unmarshalDocument(doc bson.D, value interface{}) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, value)
+}
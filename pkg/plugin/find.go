@@ -0,0 +1,127 @@
+package plugin
+
+import (
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type queryMode = string
+
+const (
+	queryModeAggregate = "Aggregate"
+	queryModeFind      = "Find"
+	defaultQueryMode   = queryModeAggregate
+)
+
+func (m *QueryModel) getQueryMode() queryMode {
+	if m.QueryMode == "" {
+		return defaultQueryMode
+	}
+	return m.QueryMode
+}
+
+// parseExtJSONDocument parses an optional Extended JSON document from a query, expanding any macros
+func (m *QueryModel) parseExtJSONDocument(name string, extJSON string, r queryRange) (bson.D, error) {
+	if extJSON == "" {
+		return bson.D{}, nil
+	}
+	doc := bson.D{}
+	err := bson.UnmarshalExtJSON([]byte(extJSON), false, &doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse "+name)
+	}
+	expanded, err := m.expandMacros(doc, r)
+	if err != nil {
+		return nil, err
+	}
+	return expanded.(bson.D), nil
+}
+
+// usesAutoTimeBound returns true if the query type supports, and the query has enabled, the automatic time bound
+func (m *QueryModel) usesAutoTimeBound() bool {
+	if !m.AutoTimeBound {
+		return false
+	}
+	switch m.QueryType {
+	case queryTypeTimeseries, queryTypeCount, queryTypeDistinct, queryTypeAnnotations, queryTypeLogs:
+		return true
+	default:
+		return false
+	}
+}
+
+// getFilter produces the filter for a find, count, or distinct, combined with the automatic time bound, if enabled
+func (m *QueryModel) getFilter(r queryRange) (bson.D, error) {
+	filter, err := m.parseExtJSONDocument("filter", m.Filter, r)
+	if err != nil {
+		return nil, err
+	}
+	if !m.usesAutoTimeBound() {
+		return filter, nil
+	}
+	timeBound, err := m.getFieldTimeBoundMatch(m.TimestampField, r.From, r.To)
+	if err != nil {
+		return nil, err
+	}
+	if len(filter) == 0 {
+		return timeBound, nil
+	}
+	return bson.D{bson.E{Key: "$and", Value: bson.A{filter, timeBound}}}, nil
+}
+
+// getFindArgs produces the filter and options to call find() with.
+// The automatic time bound is combined with the filter, and the automatic time sort, or the
+// newest-first sort and limit of a Logs query, take precedence over the user-provided ones,
+// in the same way as they are added to the end of an aggregation pipeline
+func (m *QueryModel) getFindArgs(r queryRange) (bson.D, *mongoOpts.FindOptions, error) {
+	if m.QueryType == queryTypeTimeseries && m.AutoBucket {
+		return nil, nil, errors.New("Automatic time buckets require the " + queryModeAggregate + " query mode")
+	}
+
+	filter, err := m.getFilter(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := mongoOpts.Find()
+
+	projection, err := m.parseExtJSONDocument("projection", m.Projection, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(projection) != 0 {
+		opts.SetProjection(projection)
+	}
+
+	sort, err := m.parseExtJSONDocument("sort", m.Sort, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.QueryType == queryTypeTimeseries && m.AutoTimeSort {
+		timeSort := bson.D{bson.E{Key: m.TimestampField, Value: 1}}
+		for _, elem := range sort {
+			if elem.Key != m.TimestampField {
+				timeSort = append(timeSort, elem)
+			}
+		}
+		sort = timeSort
+	}
+	if m.QueryType == queryTypeLogs {
+		sort = bson.D{bson.E{Key: m.TimestampField, Value: -1}}
+	}
+	if len(sort) != 0 {
+		opts.SetSort(sort)
+	}
+
+	if m.Skip > 0 {
+		opts.SetSkip(m.Skip)
+	}
+	if m.QueryType == queryTypeLogs {
+		opts.SetLimit(m.getLogsLimit())
+	} else if m.Limit > 0 {
+		opts.SetLimit(m.Limit)
+	}
+
+	return filter, opts, nil
+}
@@ -0,0 +1,167 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// getIndexStatsFields returns the fixed schema of an index stats query.
+// Access statistics are absent for indexes which are still being built.
+func getIndexStatsFields() []field {
+	return []field{
+		{Name: "collection", Type: data.FieldTypeString},
+		{Name: "name", Type: data.FieldTypeString},
+		{Name: "key", Type: data.FieldTypeJSON},
+		{Name: "accesses", Type: data.FieldTypeNullableInt64},
+		{Name: "since", Type: data.FieldTypeNullableTime},
+		{Name: "size", Type: data.FieldTypeNullableInt64},
+		{Name: "host", Type: data.FieldTypeNullableString},
+	}
+}
+
+// runIndexStats produces a document for each index of the collection, or of every collection in the database
+// if no collection is specified. Indexes on sharded collections have a document for each host.
+func (m *QueryModel) runIndexStats(ctx context.Context, database *mongo.Database) (documentSource, error) {
+	var collections []string
+	if m.Collection != "" {
+		collections = []string{m.Collection}
+	} else {
+		names, err := database.ListCollectionNames(ctx, bson.D{bson.E{Key: "type", Value: "collection"}})
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to list collections")
+		}
+		for _, name := range names {
+			if strings.HasPrefix(name, "system.") {
+				continue
+			}
+			collections = append(collections, name)
+		}
+	}
+
+	docs := make([]bson.D, 0)
+	for _, name := range collections {
+		collectionDocs, err := getIndexStats(ctx, database.Collection(name))
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to get index stats for "+name)
+		}
+		docs = append(docs, collectionDocs...)
+	}
+	return newSliceSource(docs), nil
+}
+
+// indexStat is the subset of a document returned by $indexStats used by this plugin.
+// Indexes on sharded collections have a document for each shard.
+type indexStat struct {
+	Name     string `bson:"name"`
+	Host     string `bson:"host"`
+	Shard    string `bson:"shard"`
+	Accesses struct {
+		Ops   int64       `bson:"ops"`
+		Since interface{} `bson:"since"`
+	} `bson:"accesses"`
+}
+
+// collStatsShard is the subset of a document returned by $collStats used by this plugin.
+// Sharded collections have a document for each shard.
+type collStatsShard struct {
+	Shard        string `bson:"shard"`
+	StorageStats struct {
+		IndexSizes map[string]int64 `bson:"indexSizes"`
+	} `bson:"storageStats"`
+}
+
+// getIndexStats produces a document for each index of a collection, in the order returned by listIndexes
+func getIndexStats(ctx context.Context, collection *mongo.Collection) ([]bson.D, error) {
+	specs, err := collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{{bson.E{Key: "$indexStats", Value: bson.D{}}}})
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]indexStat, 0, len(specs))
+	err = cursor.All(ctx, &stats)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err = collection.Aggregate(ctx, mongo.Pipeline{{bson.E{
+		Key:   "$collStats",
+		Value: bson.D{bson.E{Key: "storageStats", Value: bson.D{}}},
+	}}})
+	if err != nil {
+		return nil, err
+	}
+	collStats := make([]collStatsShard, 0, 1)
+	err = cursor.All(ctx, &collStats)
+	if err != nil {
+		return nil, err
+	}
+	log.DefaultLogger.Debug("Index stats", "collection", collection.Name(), "specs", specs, "stats", stats, "collStats", collStats)
+
+	return getIndexStatsRows(collection.Name(), specs, stats, collStats)
+}
+
+// getIndexStatsRows merges the specifications of the indexes of a collection with their access statistics and
+// sizes, producing a row for each index on each shard. The size of an index on a shard is the size reported by
+// that shard. Indexes without statistics, such as those still being built, have a single row with their total size.
+func getIndexStatsRows(collection string, specs []*mongo.IndexSpecification, stats []indexStat, collStats []collStatsShard) ([]bson.D, error) {
+	totalSizes := make(map[string]int64)
+	shardSizes := make(map[string]map[string]int64, len(collStats))
+	for _, shard := range collStats {
+		shardSizes[shard.Shard] = shard.StorageStats.IndexSizes
+		for name, size := range shard.StorageStats.IndexSizes {
+			totalSizes[name] += size
+		}
+	}
+
+	docs := make([]bson.D, 0, len(specs))
+	for _, spec := range specs {
+		key := bson.D{}
+		err := bson.Unmarshal(spec.KeysDocument, &key)
+		if err != nil {
+			return nil, err
+		}
+		row := bson.D{
+			bson.E{Key: "collection", Value: collection},
+			bson.E{Key: "name", Value: spec.Name},
+			bson.E{Key: "key", Value: key},
+		}
+		found := false
+		for _, stat := range stats {
+			if stat.Name != spec.Name {
+				continue
+			}
+			found = true
+			size, ok := totalSizes[spec.Name]
+			if stat.Shard != "" {
+				size, ok = shardSizes[stat.Shard][spec.Name]
+			}
+			// Limit the capacity so that each shard gets its own copy of the row
+			statRow := row[:len(row):len(row)]
+			if ok {
+				statRow = append(statRow, bson.E{Key: "size", Value: size})
+			}
+			docs = append(docs, append(statRow,
+				bson.E{Key: "accesses", Value: stat.Accesses.Ops},
+				bson.E{Key: "since", Value: stat.Accesses.Since},
+				bson.E{Key: "host", Value: stat.Host},
+			))
+		}
+		if !found {
+			if size, ok := totalSizes[spec.Name]; ok {
+				row = append(row, bson.E{Key: "size", Value: size})
+			}
+			docs = append(docs, row)
+		}
+	}
+	return docs, nil
+}
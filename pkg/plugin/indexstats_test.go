@@ -0,0 +1,126 @@
+package plugin_test
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	bsonprim "go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IndexStatsRows", func() {
+	since := bsonprim.NewDateTimeFromTime(nowMillis)
+	specs := []bson.D{
+		{{Key: "v", Value: int32(2)}, {Key: "key", Value: bson.D{{Key: "_id", Value: int32(1)}}}, {Key: "name", Value: "_id_"}},
+		{{Key: "v", Value: int32(2)}, {Key: "key", Value: bson.D{{Key: "a", Value: int32(1)}, {Key: "b", Value: int32(-1)}}}, {Key: "name", Value: "a_1_b_-1"}},
+	}
+	// indexStat produces the $indexStats document of an index, on a shard if one is given
+	indexStat := func(name, host, shard string, ops int64) bson.D {
+		stat := bson.D{
+			{Key: "name", Value: name},
+			{Key: "key", Value: bson.D{}},
+			{Key: "host", Value: host},
+			{Key: "accesses", Value: bson.D{{Key: "ops", Value: ops}, {Key: "since", Value: since}}},
+		}
+		if shard != "" {
+			stat = append(stat, bson.E{Key: "shard", Value: shard})
+		}
+		return stat
+	}
+	// collStats produces the $collStats document of the collection, on a shard if one is given
+	collStats := func(shard string, idSize, abSize int64) bson.D {
+		doc := bson.D{
+			{Key: "ns", Value: "my_db.my_collection"},
+			{Key: "storageStats", Value: bson.D{{Key: "indexSizes", Value: bson.D{
+				{Key: "_id_", Value: idSize},
+				{Key: "a_1_b_-1", Value: abSize},
+			}}}},
+		}
+		if shard != "" {
+			doc = append(doc, bson.E{Key: "shard", Value: shard})
+		}
+		return doc
+	}
+	// row produces the expected row of an index
+	row := func(name string, key bson.D, size int64, ops int64, host string) bson.D {
+		return bson.D{
+			{Key: "collection", Value: "my_collection"},
+			{Key: "name", Value: name},
+			{Key: "key", Value: key},
+			{Key: "size", Value: size},
+			{Key: "accesses", Value: ops},
+			{Key: "since", Value: since},
+			{Key: "host", Value: host},
+		}
+	}
+	idKey := bson.D{{Key: "_id", Value: int32(1)}}
+	abKey := bson.D{{Key: "a", Value: int32(1)}, {Key: "b", Value: int32(-1)}}
+
+	DescribeTable("should produce",
+		func(stats, collStats, expected []bson.D) {
+			rows, err := plugin.GetIndexStatsRows("my_collection", specs, stats, collStats)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rows).To(Equal(expected))
+		},
+		Entry("a row for each index of an unsharded collection, in the order of listIndexes",
+			[]bson.D{
+				indexStat("a_1_b_-1", "db:27017", "", 3),
+				indexStat("_id_", "db:27017", "", 5),
+			},
+			[]bson.D{collStats("", 100, 200)},
+			[]bson.D{
+				row("_id_", idKey, 100, 5, "db:27017"),
+				row("a_1_b_-1", abKey, 200, 3, "db:27017"),
+			},
+		),
+		Entry("a row for each index on each shard of a sharded collection, with the size on that shard",
+			[]bson.D{
+				indexStat("_id_", "shard0:27018", "shard0", 5),
+				indexStat("_id_", "shard1:27018", "shard1", 7),
+				indexStat("a_1_b_-1", "shard1:27018", "shard1", 2),
+				indexStat("a_1_b_-1", "shard0:27018", "shard0", 3),
+			},
+			[]bson.D{collStats("shard1", 10, 20), collStats("shard0", 100, 200)},
+			[]bson.D{
+				row("_id_", idKey, 100, 5, "shard0:27018"),
+				row("_id_", idKey, 10, 7, "shard1:27018"),
+				row("a_1_b_-1", abKey, 20, 2, "shard1:27018"),
+				row("a_1_b_-1", abKey, 200, 3, "shard0:27018"),
+			},
+		),
+		Entry("a row with the total size for an index without statistics",
+			[]bson.D{indexStat("_id_", "shard0:27018", "shard0", 5)},
+			[]bson.D{collStats("shard0", 100, 200), collStats("shard1", 10, 20)},
+			[]bson.D{
+				row("_id_", idKey, 100, 5, "shard0:27018"),
+				{
+					{Key: "collection", Value: "my_collection"},
+					{Key: "name", Value: "a_1_b_-1"},
+					{Key: "key", Value: abKey},
+					{Key: "size", Value: int64(220)},
+				},
+			},
+		),
+		Entry("rows without sizes when the sizes are not reported",
+			[]bson.D{indexStat("_id_", "db:27017", "", 5)},
+			[]bson.D{},
+			[]bson.D{
+				{
+					{Key: "collection", Value: "my_collection"},
+					{Key: "name", Value: "_id_"},
+					{Key: "key", Value: idKey},
+					{Key: "accesses", Value: int64(5)},
+					{Key: "since", Value: since},
+					{Key: "host", Value: "db:27017"},
+				},
+				{
+					{Key: "collection", Value: "my_collection"},
+					{Key: "name", Value: "a_1_b_-1"},
+					{Key: "key", Value: abKey},
+				},
+			},
+		),
+	)
+})
@@ -0,0 +1,121 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The names of the fields of a frame which Grafana reads log lines from. The time field is named after the Timestamp Field
+const (
+	logsBodyField  = "body"
+	logsLevelField = "level"
+)
+
+// defaultLogsLimit is the most log lines returned by a Logs query if no limit is specified
+const defaultLogsLimit = 1000
+
+// getLogsFields returns the fixed schema of a logs query
+func getLogsFields() []field {
+	return []field{
+		{Name: logsBodyField, Type: data.FieldTypeNullableString},
+		{Name: logsLevelField, Type: data.FieldTypeNullableString},
+	}
+}
+
+// getLogsLimit returns the most log lines a Logs query returns
+func (m *QueryModel) getLogsLimit() int64 {
+	if m.Limit <= 0 {
+		return defaultLogsLimit
+	}
+	return m.Limit
+}
+
+// getLogsPipelineStages produces the stages added to the end of the pipeline of a Logs query,
+// which return the newest log lines first, up to the limit
+func (m *QueryModel) getLogsPipelineStages() []bson.D {
+	return []bson.D{
+		{bson.E{Key: "$sort", Value: bson.D{bson.E{Key: m.TimestampField, Value: -1}}}},
+		{bson.E{Key: "$limit", Value: m.getLogsLimit()}},
+	}
+}
+
+// logsQueryModel produces a frame for each unique combination of labels, with a row for each log line,
+// newest first. The labels are attached to the body field, which is how Grafana identifies log streams.
+type logsQueryModel struct {
+	timestampFieldName   string
+	timestampFieldFormat string
+	bodyFieldName        string
+	levelFieldName       string
+	labelFieldNames      []string
+	fields               []field
+}
+
+var _ = resolvedQueryModel(&logsQueryModel{})
+
+func (m *logsQueryModel) makeFrame(id string, labels data.Labels) (*data.Frame, error) {
+	frame := data.NewFrame(id, data.NewFieldFromFieldType(data.FieldTypeTime, 0))
+	frame.Fields[0].Name = m.timestampFieldName
+	for _, f := range m.fields {
+		newField, err := m.makeField(f, labels, 0)
+		if err != nil {
+			return nil, err
+		}
+		frame.Fields = append(frame.Fields, newField)
+	}
+	frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeLogs}
+	return frame, nil
+}
+
+func (m *logsQueryModel) getFields() ([]field, int) {
+	return m.fields, 1
+}
+
+func (m *logsQueryModel) setFields(fields []field) {
+	m.fields = fields
+}
+
+func (m *logsQueryModel) makeField(f field, labels data.Labels, length int) (*data.Field, error) {
+	newField := data.NewFieldFromFieldType(f.Type, length)
+	newField.Name = f.Name
+	if f.Name == logsBodyField {
+		newField.Labels = labels
+	}
+	return newField, nil
+}
+
+func (m *logsQueryModel) getLabels(doc timestepDocument) (data.Labels, string) {
+	return getLabelsFromFields(doc, m.labelFieldNames)
+}
+
+func (m *logsQueryModel) getValues(doc timestepDocument) ([]interface{}, error) {
+	values := make([]interface{}, 1+len(m.fields))
+
+	timestamp, ok := lookupPath(doc, m.timestampFieldName)
+	if !ok {
+		return nil, fmt.Errorf("All documents must have the Timestamp Field present")
+	}
+	var err error
+	values[0], err = convertTimestamp(timestamp, m.timestampFieldFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	valueValues := values[1:]
+	for ix, f := range m.fields {
+		var value *string
+		switch f.Name {
+		case logsBodyField:
+			value, err = lookupText(doc, m.bodyFieldName)
+		case logsLevelField:
+			value, err = lookupText(doc, m.levelFieldName)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Failed to convert value for %s", f.Name))
+		}
+		valueValues[ix] = value
+	}
+	return values, nil
+}
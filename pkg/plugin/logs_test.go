@@ -0,0 +1,82 @@
+package plugin_test
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonprim "go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logs", func() {
+	qm := &plugin.QueryModel{
+		QueryType:      "Logs",
+		TimestampField: "ts",
+		LogsBodyField:  "msg",
+		LogsLevelField: "severity",
+		LabelFields:    []string{"service"},
+	}
+	line := func(seconds int, service string, msg interface{}, severity interface{}) bson.D {
+		doc := bson.D{
+			{Key: "ts", Value: bsonprim.NewDateTimeFromTime(nowMillis.Add(time.Duration(seconds) * time.Second))},
+			{Key: "service", Value: service},
+			{Key: "msg", Value: msg},
+		}
+		if severity != nil {
+			doc = append(doc, bson.E{Key: "severity", Value: severity})
+		}
+		return doc
+	}
+
+	It("Should produce a log frame for each stream, labeling the body", func() {
+		res := plugin.ReadDocuments(qm, []bson.D{
+			line(2, "api", "second", "error"),
+			line(1, "worker", "first", "info"),
+			line(0, "api", "oldest", nil),
+		})
+		Expect(res.Error).ToNot(HaveOccurred())
+		Expect(res.Frames).To(HaveLen(2))
+		for _, frame := range res.Frames {
+			Expect(fieldNames(frame)).To(Equal([]string{"ts", "body", "level"}))
+			Expect(frame.Meta.PreferredVisualization).To(Equal(data.VisType(data.VisTypeLogs)))
+			Expect(frame.Fields[0].Labels).To(BeEmpty())
+			Expect(frame.Fields[2].Labels).To(BeEmpty())
+		}
+
+		api := res.Frames[0]
+		Expect(api.Fields[1].Labels).To(Equal(data.Labels{"service": "api"}))
+		Expect(api.Rows()).To(Equal(2))
+		Expect(api.Fields[1].At(0)).To(Equal(stringPointer("second")))
+		Expect(api.Fields[2].At(0)).To(Equal(stringPointer("error")))
+		Expect(api.Fields[1].At(1)).To(Equal(stringPointer("oldest")))
+		Expect(api.Fields[2].At(1)).To(BeNil())
+
+		Expect(res.Frames[1].Fields[1].Labels).To(Equal(data.Labels{"service": "worker"}))
+	})
+
+	DescribeTable("should convert the body of",
+		func(msg interface{}, expected *string) {
+			res := plugin.ReadDocuments(qm, []bson.D{line(0, "api", msg, nil)})
+			Expect(res.Error).ToNot(HaveOccurred())
+			if expected == nil {
+				Expect(res.Frames[0].Fields[1].At(0)).To(BeNil())
+				return
+			}
+			Expect(res.Frames[0].Fields[1].At(0)).To(Equal(expected))
+		},
+		Entry("a string to itself", "hello", stringPointer("hello")),
+		Entry("a number to a string", int32(42), stringPointer("42")),
+		Entry("a document to JSON", bson.D{{Key: "event", Value: "login"}}, stringPointer(`{"event":"login"}`)),
+		Entry("a null to an absent body", nil, nil),
+	)
+
+	It("Should require the timestamp field", func() {
+		res := plugin.ReadDocuments(qm, []bson.D{{{Key: "msg", Value: "no time"}}})
+		Expect(res.Error).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Macros are strings which, when they appear as a complete string value anywhere in an aggregation
+// pipeline, are replaced server-side with a value derived from the query. Because they are expanded
+// by the backend, they produce proper BSON types, and work for alert rules as well as panels.
+const (
+	// macroTimeFrom is replaced with the start of the query time range, as a BSON DateTime
+	macroTimeFrom = "$__timeFrom"
+	// macroTimeTo is replaced with the end of the query time range, as a BSON DateTime
+	macroTimeTo = "$__timeTo"
+	// macroIntervalMs is replaced with the query interval in milliseconds, as an int64
+	macroIntervalMs = "$__interval_ms"
+	// macroMaxDataPoints is replaced with the maximum number of data points for the query, as an int64
+	macroMaxDataPoints = "$__maxDataPoints"
+)
+
+// macroTimeFilter is replaced with a $match document restricting the named field to the query time range,
+// e.g. {"$match": "$__timeFilter(timestamp)"}
+var macroTimeFilter = regexp.MustCompile(`^\$__timeFilter\(\s*([^)\s]+)\s*\)$`)
+
+// expandMacros returns a copy of a value from a parsed pipeline, with all macros replaced
+func (m *QueryModel) expandMacros(value interface{}, r queryRange) (interface{}, error) {
+	var err error
+	switch v := value.(type) {
+	case string:
+		return m.expandMacro(v, r)
+	case bson.D:
+		expanded := make(bson.D, len(v))
+		for ix, elem := range v {
+			expanded[ix].Key = elem.Key
+			expanded[ix].Value, err = m.expandMacros(elem.Value, r)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return expanded, nil
+	case bson.M:
+		expanded := make(bson.M, len(v))
+		for key, elem := range v {
+			expanded[key], err = m.expandMacros(elem, r)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return expanded, nil
+	case bson.A:
+		expanded := make(bson.A, len(v))
+		for ix, elem := range v {
+			expanded[ix], err = m.expandMacros(elem, r)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return expanded, nil
+	case []interface{}:
+		expanded := make([]interface{}, len(v))
+		for ix, elem := range v {
+			expanded[ix], err = m.expandMacros(elem, r)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return expanded, nil
+	}
+	return value, nil
+}
+
+func (m *QueryModel) expandMacro(value string, r queryRange) (interface{}, error) {
+	switch value {
+	case macroTimeFrom:
+		return bsonPrim.NewDateTimeFromTime(r.From), nil
+	case macroTimeTo:
+		return bsonPrim.NewDateTimeFromTime(r.To), nil
+	case macroIntervalMs:
+		return r.Interval.Milliseconds(), nil
+	case macroMaxDataPoints:
+		return r.MaxDataPoints, nil
+	}
+	if match := macroTimeFilter.FindStringSubmatch(value); match != nil {
+		filter, err := m.getFieldTimeBoundMatch(match[1], r.From, r.To)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to expand %s: %s", value, err)
+		}
+		return filter, nil
+	}
+	return value, nil
+}
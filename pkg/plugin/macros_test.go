@@ -0,0 +1,75 @@
+package plugin_test
+
+import (
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	bsonprim "go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ExpandMacros", func() {
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	fromDate := bsonprim.NewDateTimeFromTime(from)
+	toDate := bsonprim.NewDateTimeFromTime(to)
+
+	DescribeTable("should expand",
+		func(qm plugin.QueryModel, value interface{}, expected interface{}) {
+			expanded, err := plugin.ExpandMacros(&qm, value, from, to, 30*time.Second, 120)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(expanded).To(Equal(expected))
+		},
+		Entry("the start of the time range", plugin.QueryModel{}, "$__timeFrom", fromDate),
+		Entry("the end of the time range", plugin.QueryModel{}, "$__timeTo", toDate),
+		Entry("the interval", plugin.QueryModel{}, "$__interval_ms", int64(30000)),
+		Entry("the max data points", plugin.QueryModel{}, "$__maxDataPoints", int64(120)),
+		Entry("other strings to themselves", plugin.QueryModel{}, "$timestamp", "$timestamp"),
+		Entry("macros nested in documents and arrays",
+			plugin.QueryModel{},
+			bson.D{{Key: "$match", Value: bson.D{{Key: "ts", Value: bson.D{{Key: "$in", Value: bson.A{"$__timeFrom", int32(1)}}}}}}},
+			bson.D{{Key: "$match", Value: bson.D{{Key: "ts", Value: bson.D{{Key: "$in", Value: bson.A{fromDate, int32(1)}}}}}}},
+		),
+		Entry("a time filter on a date field",
+			plugin.QueryModel{},
+			"$__timeFilter(timestamp)",
+			bson.D{{Key: "timestamp", Value: bson.D{{Key: "$gte", Value: fromDate}, {Key: "$lte", Value: toDate}}}},
+		),
+		Entry("a time filter on a BSON Timestamp field",
+			plugin.QueryModel{TimestampField: "ts", TimestampType: "Timestamp"},
+			"$__timeFilter( ts )",
+			bson.D{{Key: "ts", Value: bson.D{
+				{Key: "$gte", Value: bsonprim.Timestamp{T: uint32(from.Unix()), I: 0}},
+				{Key: "$lte", Value: bsonprim.Timestamp{T: uint32(to.Unix()), I: math.MaxUint32}},
+			}}},
+		),
+	)
+})
+
+var _ = Describe("GetBSONTimestampBoundMatch", func() {
+	DescribeTable("should include every timestamp within",
+		func(from, to time.Time, expectedFrom, expectedTo bsonprim.Timestamp) {
+			Expect(plugin.GetBSONTimestampBoundMatch("ts", from, to)).To(Equal(bson.D{{Key: "ts", Value: bson.D{
+				{Key: "$gte", Value: expectedFrom},
+				{Key: "$lte", Value: expectedTo},
+			}}}))
+		},
+		Entry("whole seconds",
+			time.Unix(1000, 0), time.Unix(2000, 0),
+			bsonprim.Timestamp{T: 1000, I: 0}, bsonprim.Timestamp{T: 2000, I: math.MaxUint32},
+		),
+		Entry("the first and last seconds of a range with fractional seconds",
+			time.Unix(1000, 500000000), time.Unix(2000, 999000000),
+			bsonprim.Timestamp{T: 1000, I: 0}, bsonprim.Timestamp{T: 2000, I: math.MaxUint32},
+		),
+		Entry("a single second",
+			time.Unix(1000, 0), time.Unix(1000, 0),
+			bsonprim.Timestamp{T: 1000, I: 0}, bsonprim.Timestamp{T: 1000, I: math.MaxUint32},
+		),
+	)
+})
@@ -2,6 +2,8 @@ package plugin
 
 import (
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
@@ -17,29 +19,87 @@ import (
 type queryType = string
 
 const (
-	queryTypeTimeseries = "Timeseries"
-	queryTypeTable      = "Table"
-	defaultQueryType    = queryTypeTable
+	queryTypeTimeseries   = "Timeseries"
+	queryTypeTable        = "Table"
+	queryTypeCount        = "Count"
+	queryTypeDistinct     = "Distinct"
+	queryTypeCommand      = "Command"
+	queryTypeServerStatus = "ServerStatus"
+	queryTypeReplicaSet   = "ReplicaSet"
+	queryTypeIndexStats   = "IndexStats"
+	queryTypeCurrentOp    = "CurrentOp"
+	queryTypeOplog        = "Oplog"
+	queryTypeProfiler     = "Profiler"
+	queryTypeAnnotations  = "Annotations"
+	queryTypeLogs         = "Logs"
+	defaultQueryType      = queryTypeTable
+)
+
+// Types of BSON value which can contain a timestamp. Dates are the default.
+// If a timestamp format is provided, date timestamps are instead parsed from strings
+const (
+	timestampTypeDate      = "Date"
+	timestampTypeTimestamp = "Timestamp"
 )
 
 type QueryModel struct {
-	Database             string    `json:"database"`
-	Collection           string    `json:"collection"`
-	QueryType            queryType `json:"queryType"`
-	TimestampField       string    `json:"timestampField,omitempty"`
-	TimestampFormat      string    `json:"timestampFormat,omitempty"`
-	LabelFields          []string  `json:"labelFields,omitempty"`
-	LegendFormat         string    `json:"legendFormat,omitempty"`
-	ValueFields          []string  `json:"valueFields"`
-	ValueFieldTypes      []string  `json:"valueFieldTypes,omitempty"`
-	AutoTimeBound        bool      `json:"autoTimeBound"`
-	AutoTimeBoundAtStart bool      `json:"autoTimeBoundAtStart"`
-	AutoTimeSort         bool      `json:"autoTimeSort"`
-	Aggregation          string    `json:"aggregation"`
-	SchemaInference      bool      `json:"schemaInference"`
-	SchemaInferenceDepth int       `json:"schemaInferenceDepth,omitempty"`
+	Database                        string     `json:"database"`
+	Collection                      string     `json:"collection"`
+	QueryType                       queryType  `json:"queryType"`
+	QueryMode                       queryMode  `json:"queryMode,omitempty"`
+	TimestampField                  string     `json:"timestampField,omitempty"`
+	TimestampFormat                 string     `json:"timestampFormat,omitempty"`
+	TimestampType                   string     `json:"timestampType,omitempty"`
+	LabelFields                     []string   `json:"labelFields,omitempty"`
+	LegendFormat                    string     `json:"legendFormat,omitempty"`
+	SortByLabels                    bool       `json:"sortByLabels,omitempty"`
+	ValueFields                     []string   `json:"valueFields"`
+	ValueFieldTypes                 []string   `json:"valueFieldTypes,omitempty"`
+	AutoTimeBound                   bool       `json:"autoTimeBound"`
+	AutoTimeBoundAtStart            bool       `json:"autoTimeBoundAtStart"`
+	AutoTimeSort                    bool       `json:"autoTimeSort"`
+	Aggregation                     string     `json:"aggregation"`
+	Filter                          string     `json:"filter,omitempty"`
+	Projection                      string     `json:"projection,omitempty"`
+	Sort                            string     `json:"sort,omitempty"`
+	Skip                            int64      `json:"skip,omitempty"`
+	Limit                           int64      `json:"limit,omitempty"`
+	DistinctField                   string     `json:"distinctField,omitempty"`
+	Command                         string     `json:"command,omitempty"`
+	CommandCursor                   bool       `json:"commandCursor,omitempty"`
+	CommandResultPath               string     `json:"commandResultPath,omitempty"`
+	ServerStatusReplication         bool       `json:"serverStatusReplication,omitempty"`
+	CurrentOpMinSecsRunning         int64      `json:"currentOpMinSecsRunning,omitempty"`
+	CurrentOpNamespace              string     `json:"currentOpNamespace,omitempty"`
+	CurrentOpType                   string     `json:"currentOpType,omitempty"`
+	ProfilerTopN                    int        `json:"profilerTopN,omitempty"`
+	ProfilerRankBy                  string     `json:"profilerRankBy,omitempty"`
+	StreamMode                      streamMode `json:"streamMode,omitempty"`
+	ChangeStreamMatch               string     `json:"changeStreamMatch,omitempty"`
+	PollIntervalSeconds             int        `json:"pollIntervalSeconds,omitempty"`
+	PollWindowSeconds               int        `json:"pollWindowSeconds,omitempty"`
+	TailFlushIntervalMs             int        `json:"tailFlushIntervalMs,omitempty"`
+	TailBufferSize                  int        `json:"tailBufferSize,omitempty"`
+	AnnotationTimeEndField          string     `json:"annotationTimeEndField,omitempty"`
+	AnnotationTitleField            string     `json:"annotationTitleField,omitempty"`
+	AnnotationTextField             string     `json:"annotationTextField,omitempty"`
+	AnnotationTagsField             string     `json:"annotationTagsField,omitempty"`
+	LogsBodyField                   string     `json:"logsBodyField,omitempty"`
+	LogsLevelField                  string     `json:"logsLevelField,omitempty"`
+	SchemaInference                 bool       `json:"schemaInference"`
+	SchemaInferenceDepth            int        `json:"schemaInferenceDepth,omitempty"`
+	SchemaInferenceFallbackToString bool       `json:"schemaInferenceFallbackToString,omitempty"`
+	FlattenDocuments                bool       `json:"flattenDocuments,omitempty"`
+	FlattenMaxDepth                 int        `json:"flattenMaxDepth,omitempty"`
+	ExpandArrayField                string     `json:"expandArrayField,omitempty"`
+	AutoBucket                      bool       `json:"autoBucket,omitempty"`
+	AutoBucketReducers              []string   `json:"autoBucketReducers,omitempty"`
 }
 
+// maxFlattenDepth is used when flattening is enabled without a max depth.
+// This is the maximum nesting depth of a BSON document
+const maxFlattenDepth = 100
+
 func (m *QueryModel) resolve(fields []field) (resolvedQueryModel, error) {
 	var err error
 
@@ -48,9 +108,10 @@ func (m *QueryModel) resolve(fields []field) (resolvedQueryModel, error) {
 		queryType = defaultQueryType
 	}
 	switch queryType {
-	case queryTypeTable:
+	case queryTypeTable, queryTypeCount, queryTypeDistinct, queryTypeCommand, queryTypeIndexStats, queryTypeCurrentOp:
 		return &tableQueryModel{
-			fields: fields,
+			fields:           fields,
+			fallbackToString: m.usesFallbackToString(),
 		}, nil
 	case queryTypeTimeseries:
 		var legendTemplate *template.Template
@@ -60,15 +121,58 @@ func (m *QueryModel) resolve(fields []field) (resolvedQueryModel, error) {
 				return nil, err
 			}
 		}
+		timestampFormat := m.TimestampFormat
+		if m.AutoBucket || m.usesBSONTimestamps() {
+			// Bucketed timestamps are always produced as dates, and BSON timestamps are never strings
+			timestampFormat = ""
+		}
 		return &timeseriesQueryModel{
 			fields:               fields,
 			timestampFieldName:   m.TimestampField,
-			timestampFieldFormat: m.TimestampFormat,
+			timestampFieldFormat: timestampFormat,
 			labelFieldNames:      m.LabelFields,
 			legendTemplate:       legendTemplate,
+			fallbackToString:     m.usesFallbackToString(),
+		}, nil
+	case queryTypeAnnotations:
+		timestampFormat := m.TimestampFormat
+		if m.usesBSONTimestamps() {
+			timestampFormat = ""
+		}
+		return &annotationQueryModel{
+			timestampFieldName:   m.TimestampField,
+			timestampFieldFormat: timestampFormat,
+			timeEndFieldName:     m.AnnotationTimeEndField,
+			titleFieldName:       m.AnnotationTitleField,
+			textFieldName:        m.AnnotationTextField,
+			tagsFieldName:        m.AnnotationTagsField,
+			fields:               fields,
+		}, nil
+	case queryTypeLogs:
+		timestampFormat := m.TimestampFormat
+		if m.usesBSONTimestamps() {
+			timestampFormat = ""
+		}
+		return &logsQueryModel{
+			timestampFieldName:   m.TimestampField,
+			timestampFieldFormat: timestampFormat,
+			bodyFieldName:        m.LogsBodyField,
+			levelFieldName:       m.LogsLevelField,
+			labelFieldNames:      m.LabelFields,
+			fields:               fields,
+		}, nil
+	case queryTypeServerStatus:
+		return &timeseriesQueryModel{
+			fields:             fields,
+			timestampFieldName: serverStatusTimeField,
 		}, nil
 	default:
-		return nil, fmt.Errorf("Query type must be one of: %s, %s", queryTypeTable, queryTypeTimeseries)
+		return nil, fmt.Errorf(
+			"Query type must be one of: %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s",
+			queryTypeTable, queryTypeTimeseries, queryTypeCount, queryTypeDistinct, queryTypeCommand,
+			queryTypeServerStatus, queryTypeReplicaSet, queryTypeIndexStats, queryTypeCurrentOp,
+			queryTypeOplog, queryTypeProfiler, queryTypeAnnotations, queryTypeLogs,
+		)
 	}
 }
 
@@ -76,10 +180,18 @@ type resolvedQueryModel interface {
 	makeFrame(id string, labels data.Labels) (*data.Frame, error)
 	getLabels(doc timestepDocument) (labels data.Labels, labelsID string)
 	getValues(doc timestepDocument) ([]interface{}, error)
+	// getFields returns the value fields, along with the index of the first value field within each frame
+	getFields() (fields []field, offset int)
+	// setFields replaces the value fields, e.g. to revise an inferred schema
+	setFields(fields []field)
+	// makeField creates a new value field for a frame with the given labels
+	makeField(f field, labels data.Labels, length int) (*data.Field, error)
 }
 
 type tableQueryModel struct {
 	fields []field
+	// fallbackToString allows values of any type to be converted to string or JSON fields
+	fallbackToString bool
 }
 
 func (m *tableQueryModel) makeFrame(id string, labels data.Labels) (*data.Frame, error) {
@@ -96,18 +208,31 @@ func (m *tableQueryModel) makeFrame(id string, labels data.Labels) (*data.Frame,
 	return frame, nil
 }
 
+func (m *tableQueryModel) getFields() ([]field, int) {
+	return m.fields, 0
+}
+
+func (m *tableQueryModel) setFields(fields []field) {
+	m.fields = fields
+}
+
+func (m *tableQueryModel) makeField(f field, labels data.Labels, length int) (*data.Field, error) {
+	newField := data.NewFieldFromFieldType(f.Type, length)
+	newField.Name = f.Name
+	return newField, nil
+}
+
 func (m *tableQueryModel) getLabels(doc timestepDocument) (data.Labels, string) {
 	return make(data.Labels), ""
 }
 
 func (m *tableQueryModel) getValues(doc timestepDocument) ([]interface{}, error) {
 	var err error
-	var actualType data.FieldType
 	values := make([]interface{}, len(m.fields))
 	for ix, field := range m.fields {
 		name := field.Name
 		type_ := field.Type
-		value, ok := doc[name]
+		value, ok := lookupPath(doc, name)
 		if !ok || value == nil {
 			if !type_.Nullable() {
 				return nil, fmt.Errorf("Field %s was null or absent, but is not nullable. If using schema inference, please increase the depth to the first document missing this field, or manually specify the schema", name)
@@ -116,14 +241,10 @@ func (m *tableQueryModel) getValues(doc timestepDocument) ([]interface{}, error)
 			continue
 		}
 
-		values[ix], actualType, err = convertValue(value, type_.Nullable())
+		values[ix], err = convertValue(value, type_, m.fallbackToString)
 		if err != nil {
 			return nil, errors.Wrap(err, fmt.Sprintf("Failed to convert value for %s", name))
 		}
-
-		if values[ix] != nil && actualType != type_ {
-			return nil, fmt.Errorf("Type mismatch for field %s: expected %s, got %s (%#v, %#v)", name, type_, actualType, value, values[ix])
-		}
 	}
 	return values, nil
 }
@@ -136,6 +257,8 @@ type timeseriesQueryModel struct {
 	labelFieldNames      []string
 	legendTemplate       *template.Template
 	fields               []field
+	// fallbackToString allows values of any type to be converted to string or JSON fields
+	fallbackToString bool
 }
 
 var _ = resolvedQueryModel(&timeseriesQueryModel{})
@@ -154,32 +277,64 @@ func (m *timeseriesQueryModel) makeFrame(id string, labels data.Labels) (*data.F
 	frame := data.NewFrameOfFieldTypes(id, 0, types...)
 	frame.SetFieldNames(names...)
 	for _, field := range frame.Fields {
-		field.Labels = labels
-		displayName, err := m.getDisplayName(field.Name, field.Labels)
+		err := m.labelField(field, labels)
 		if err != nil {
 			return nil, err
 		}
-		if displayName == "" {
-			continue
-		}
-		field.Config = &data.FieldConfig{
-			DisplayNameFromDS: displayName,
-		}
 	}
 
 	return frame, nil
 }
 
+func (m *timeseriesQueryModel) labelField(field *data.Field, labels data.Labels) error {
+	field.Labels = labels
+	displayName, err := m.getDisplayName(field.Name, field.Labels)
+	if err != nil {
+		return err
+	}
+	if displayName == "" {
+		return nil
+	}
+	field.Config = &data.FieldConfig{
+		DisplayNameFromDS: displayName,
+	}
+	return nil
+}
+
+func (m *timeseriesQueryModel) getFields() ([]field, int) {
+	return m.fields, 1
+}
+
+func (m *timeseriesQueryModel) setFields(fields []field) {
+	m.fields = fields
+}
+
+func (m *timeseriesQueryModel) makeField(f field, labels data.Labels, length int) (*data.Field, error) {
+	newField := data.NewFieldFromFieldType(f.Type, length)
+	newField.Name = f.Name
+	err := m.labelField(newField, labels)
+	if err != nil {
+		return nil, err
+	}
+	return newField, nil
+}
+
 func (m *timeseriesQueryModel) getLabels(doc timestepDocument) (data.Labels, string) {
+	return getLabelsFromFields(doc, m.labelFieldNames)
+}
+
+// getLabelsFromFields returns the values of the label fields present in a document, along with
+// a string identifying that combination of labels
+func getLabelsFromFields(doc timestepDocument, labelFieldNames []string) (data.Labels, string) {
 	// TODO: Might not work, need to find a fast but stable way to identify a set of labels
 	// labelsID := fmt.Sprintf("%#v", map[string]string(labels))
 
-	labels := make(data.Labels, len(m.labelFieldNames))
+	labels := make(data.Labels, len(labelFieldNames))
 
 	labelsID := strings.Builder{}
 
-	for ix, key := range m.labelFieldNames {
-		value, ok := doc[key]
+	for ix, key := range labelFieldNames {
+		value, ok := lookupPath(doc, key)
 		if !ok {
 			continue
 		}
@@ -212,20 +367,27 @@ func (m *timeseriesQueryModel) getDisplayName(valueField string, labels data.Lab
 }
 
 func (m *timeseriesQueryModel) convertTimestamp(timestamp interface{}) (time.Time, error) {
-	if m.timestampFieldFormat == "" {
-		primTimestamp, isPrim := timestamp.(bsonPrim.DateTime)
-		if !isPrim {
-			return time.Time{}, fmt.Errorf("Timestamps must be bson DateTimes")
-		}
-		if isPrim {
+	return convertTimestamp(timestamp, m.timestampFieldFormat)
+}
+
+// convertTimestamp converts a BSON date or timestamp to a time,
+// or if a format is provided, parses a string in that format
+func convertTimestamp(timestamp interface{}, format string) (time.Time, error) {
+	if format == "" {
+		switch primTimestamp := timestamp.(type) {
+		case bsonPrim.DateTime:
 			return primTimestamp.Time(), nil
+		case bsonPrim.Timestamp:
+			return time.Unix(int64(primTimestamp.T), 0), nil
+		default:
+			return time.Time{}, fmt.Errorf("Timestamps must be bson DateTimes or Timestamps")
 		}
 	}
 	stringTimestamp, isString := timestamp.(string)
 	if !isString {
 		return time.Time{}, fmt.Errorf("Timestamps must be strings when Timestamp Format is supplied")
 	}
-	convertedTimestamp, err := time.Parse(m.timestampFieldFormat, stringTimestamp)
+	convertedTimestamp, err := time.Parse(format, stringTimestamp)
 	if err != nil {
 		return time.Time{}, errors.Wrap(err, "Could not parse timestamp")
 	}
@@ -236,7 +398,7 @@ func (m *timeseriesQueryModel) getValues(doc timestepDocument) ([]interface{}, e
 	var err error
 	values := make([]interface{}, 1+len(m.fields))
 
-	timestamp, ok := doc[m.timestampFieldName]
+	timestamp, ok := lookupPath(doc, m.timestampFieldName)
 	if !ok {
 		return nil, fmt.Errorf("All documents must have the Timestamp Field present")
 	}
@@ -246,12 +408,10 @@ func (m *timeseriesQueryModel) getValues(doc timestepDocument) ([]interface{}, e
 	}
 
 	valueValues := values[1:]
-	var actualType data.FieldType
 	for ix, field := range m.fields {
 		name := field.Name
-		value := doc[name]
 		type_ := field.Type
-		value, ok := doc[name]
+		value, ok := lookupPath(doc, name)
 		if !ok || value == nil {
 			if !type_.Nullable() {
 				return nil, fmt.Errorf("Field %s was null or absent, but is not nullable. If using schema inference, please increase the depth to the first document missing this field, or manually specify the schema", name)
@@ -260,19 +420,106 @@ func (m *timeseriesQueryModel) getValues(doc timestepDocument) ([]interface{}, e
 			continue
 		}
 
-		valueValues[ix], actualType, err = convertValue(value, type_.Nullable())
+		valueValues[ix], err = convertValue(value, type_, m.fallbackToString)
 		if err != nil {
 			return nil, errors.Wrap(err, fmt.Sprintf("Failed to convert value for %s (%#v)", name, value))
 		}
-		if actualType != type_ {
-			return nil, fmt.Errorf("Type mismatch for field %s: expected %s, got %s (%#v, %#v)", name, type_, actualType, value, valueValues[ix])
-		}
 	}
 
 	return values, nil
 }
 
+// getFlattenDepth returns how many levels of embedded documents to flatten into their own fields,
+// or zero if flattening is disabled
+func (m *QueryModel) getFlattenDepth() int {
+	if !m.FlattenDocuments || m.hasFixedSchema() {
+		return 0
+	}
+	if m.FlattenMaxDepth <= 0 {
+		return maxFlattenDepth
+	}
+	return m.FlattenMaxDepth
+}
+
+// getArrayExpander returns the expander for the array field of bucket pattern documents, or nil if not used
+func (m *QueryModel) getArrayExpander() *arrayExpander {
+	if m.ExpandArrayField == "" {
+		return nil
+	}
+	return &arrayExpander{
+		field:        m.ExpandArrayField,
+		flattenDepth: m.getFlattenDepth(),
+	}
+}
+
+// checkArrayExpansion returns an error if array expansion is combined with the automatic time bound,
+// time sort, or time buckets. Those stages run in MongoDB, before the documents are expanded, so the
+// timestamp field of the array elements they would act on does not exist yet.
+func (m *QueryModel) checkArrayExpansion() error {
+	if m.ExpandArrayField == "" {
+		return nil
+	}
+	if m.usesAutoTimeBound() {
+		return errors.New("The automatic time bound cannot be used with an Expand Array Field")
+	}
+	if m.QueryType == queryTypeTimeseries && m.AutoTimeSort {
+		return errors.New("The automatic time sort cannot be used with an Expand Array Field")
+	}
+	if m.QueryType == queryTypeTimeseries && m.AutoBucket {
+		return errors.New("Automatic time buckets cannot be used with an Expand Array Field")
+	}
+	return nil
+}
+
+// getInferenceIgnoredFields returns the fields which are not value fields, and so should not be inferred
+func (m *QueryModel) getInferenceIgnoredFields() map[string]struct{} {
+	ignored := make(map[string]struct{}, 2*(1+len(m.LabelFields)))
+	if m.QueryType == queryTypeTimeseries {
+		ignorePath(ignored, m.TimestampField)
+		for _, name := range m.LabelFields {
+			ignorePath(ignored, name)
+		}
+	}
+	return ignored
+}
+
+// ignorePath adds a field path to a set of fields ignored by schema inference. For a dotted path, each
+// prefix of it, such as the top-level key it starts with, is ignored as well, as the embedded document
+// containing the field would otherwise be inferred as a JSON field when it is not flattened.
+func ignorePath(ignored map[string]struct{}, path string) {
+	ignored[path] = struct{}{}
+	for ix, c := range path {
+		if c == '.' {
+			ignored[path[:ix]] = struct{}{}
+		}
+	}
+}
+
+// hasFixedSchema returns true if the query type always produces the same fields,
+// regardless of the value fields and schema inference settings
+func (m *QueryModel) hasFixedSchema() bool {
+	switch m.QueryType {
+	case queryTypeCount, queryTypeServerStatus, queryTypeIndexStats, queryTypeCurrentOp, queryTypeAnnotations, queryTypeLogs:
+		return true
+	}
+	return false
+}
+
 func (m *QueryModel) getFields() ([]field, error) {
+	switch m.QueryType {
+	case queryTypeCount:
+		return getCountFields(), nil
+	case queryTypeServerStatus:
+		return m.getServerStatusFields(), nil
+	case queryTypeIndexStats:
+		return getIndexStatsFields(), nil
+	case queryTypeCurrentOp:
+		return getCurrentOpFields(), nil
+	case queryTypeAnnotations:
+		return getAnnotationFields(), nil
+	case queryTypeLogs:
+		return getLogsFields(), nil
+	}
 	if len(m.ValueFields) != len(m.ValueFields) {
 		return nil, fmt.Errorf(
 			"Value Fields and Value Field Types must be the same length (%d vs %d)",
@@ -289,30 +536,76 @@ func (m *QueryModel) getFields() ([]field, error) {
 			return nil, fmt.Errorf("Invalid Type: %s", typeStr)
 		}
 	}
+	if m.QueryType == queryTypeTimeseries && m.AutoBucket {
+		m.setBucketedFieldTypes(fields)
+	}
 	return fields, nil
 }
 
+// usesBSONTimestamps returns true if the timestamp field contains BSON Timestamps, rather than dates
+func (m *QueryModel) usesBSONTimestamps() bool {
+	return m.TimestampType == timestampTypeTimestamp
+}
+
 func (m *QueryModel) getTimeBoundPipelineStage(from time.Time, to time.Time) (bson.D, error) {
+	match, err := m.getFieldTimeBoundMatch(m.TimestampField, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return bson.D{bson.E{
+		Key:   "$match",
+		Value: match,
+	}}, nil
+}
+
+// getFieldTimeBoundMatch produces a $match filter which restricts a field to a time range.
+// If the field is the timestamp field, its type and format are used, otherwise it must be a date
+func (m *QueryModel) getFieldTimeBoundMatch(fieldName string, from time.Time, to time.Time) (bson.D, error) {
+	if fieldName != m.TimestampField {
+		return getTimeBoundMatch(fieldName, "", from, to)
+	}
+	if m.usesBSONTimestamps() {
+		return getBSONTimestampBoundMatch(fieldName, from, to), nil
+	}
+	return getTimeBoundMatch(fieldName, m.TimestampFormat, from, to)
+}
+
+// getBSONTimestampBoundMatch produces a $match filter which restricts a BSON Timestamp field to a time range.
+// Timestamps only have a resolution of seconds, and are ordered within each second by an increment,
+// so the range is widened to include every timestamp within its first and last seconds
+func getBSONTimestampBoundMatch(timestampField string, from time.Time, to time.Time) bson.D {
+	return bson.D{bson.E{
+		Key: timestampField,
+		Value: bson.D{
+			bson.E{Key: "$gte", Value: bsonPrim.Timestamp{T: uint32(from.Unix()), I: 0}},
+			bson.E{Key: "$lte", Value: bsonPrim.Timestamp{T: uint32(to.Unix()), I: math.MaxUint32}},
+		},
+	}}
+}
+
+// getTimeBoundMatch produces a $match filter which restricts a timestamp field to a time range.
+// If format is non-empty, the field is parsed as a string in that format
+func getTimeBoundMatch(timestampField string, format string, from time.Time, to time.Time) (bson.D, error) {
 	fromTime := bsonPrim.NewDateTimeFromTime(from)
 	toTime := bsonPrim.NewDateTimeFromTime(to)
 	var match bson.D
-	if m.TimestampFormat == "" {
+	if format == "" {
 		match = bson.D{bson.E{
-			Key: m.TimestampField,
+			Key: timestampField,
 			Value: bson.D{
 				bson.E{Key: "$gte", Value: fromTime},
 				bson.E{Key: "$lte", Value: toTime},
 			},
 		}}
 	} else {
-		convertedFormat, err := ConvertGoTimeFormatToMongo(m.TimestampFormat)
+		convertedFormat, err := ConvertGoTimeFormatToMongo(format)
 		if err != nil {
 			return nil, err
 		}
 		parsedString := bson.D{bson.E{
 			Key: "$dateFromString",
 			Value: bson.D{
-				bson.E{Key: "dateString", Value: "$" + m.TimestampField},
+				bson.E{Key: "dateString", Value: fieldPathExpression(timestampField)},
 				bson.E{Key: "format", Value: convertedFormat},
 			},
 		}}
@@ -333,17 +626,57 @@ func (m *QueryModel) getTimeBoundPipelineStage(from time.Time, to time.Time) (bs
 			}},
 		}}
 	}
-	return bson.D{bson.E{
-		Key:   "$match",
-		Value: match,
-	}}, nil
+	return match, nil
+}
+
+// fieldPathExpression produces an aggregation expression which evaluates to the value at a field path.
+// Numeric path elements index into arrays, which plain "$a.0.b" expressions do not support
+func fieldPathExpression(path string) interface{} {
+	keys := strings.Split(path, ".")
+	hasIndex := false
+	for _, key := range keys {
+		if _, err := strconv.Atoi(key); err == nil {
+			hasIndex = true
+			break
+		}
+	}
+	if !hasIndex {
+		return "$" + path
+	}
+	var expr interface{} = "$" + keys[0]
+	for _, key := range keys[1:] {
+		if ix, err := strconv.Atoi(key); err == nil {
+			expr = bson.D{bson.E{Key: "$arrayElemAt", Value: bson.A{expr, ix}}}
+			continue
+		}
+		if exprPath, isPath := expr.(string); isPath {
+			expr = exprPath + "." + key
+			continue
+		}
+		expr = bson.D{bson.E{
+			Key: "$let",
+			Value: bson.D{
+				bson.E{Key: "vars", Value: bson.D{bson.E{Key: "elem", Value: expr}}},
+				bson.E{Key: "in", Value: "$$elem." + key},
+			},
+		}}
+	}
+	return expr
 }
 
-func (m *QueryModel) getPipeline(from time.Time, to time.Time) (mongo.Pipeline, error) {
+// queryRange contains the parts of a Grafana query used to build a pipeline
+type queryRange struct {
+	From          time.Time
+	To            time.Time
+	Interval      time.Duration
+	MaxDataPoints int64
+}
+
+func (m *QueryModel) getPipeline(r queryRange) (mongo.Pipeline, error) {
 	pipeline := mongo.Pipeline{}
 
-	if m.QueryType == queryTypeTimeseries && m.AutoTimeBound && m.AutoTimeBoundAtStart {
-		timeBoundStage, err := m.getTimeBoundPipelineStage(from, to)
+	if m.usesAutoTimeBound() && m.AutoTimeBoundAtStart {
+		timeBoundStage, err := m.getTimeBoundPipelineStage(r.From, r.To)
 		if err != nil {
 			return nil, err
 		}
@@ -355,22 +688,43 @@ func (m *QueryModel) getPipeline(from time.Time, to time.Time) (mongo.Pipeline,
 	if err != nil {
 		return mongo.Pipeline{}, errors.Wrap(err, "Failed to parse aggregation pipeline")
 	}
-	pipeline = append(pipeline, userPipeline...)
+	for _, stage := range userPipeline {
+		expanded, err := m.expandMacros(stage, r)
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, expanded.(bson.D))
+	}
 
-	if m.QueryType == queryTypeTimeseries && m.AutoTimeBound && !m.AutoTimeBoundAtStart {
-		timeBoundStage, err := m.getTimeBoundPipelineStage(from, to)
+	if m.usesAutoTimeBound() && !m.AutoTimeBoundAtStart {
+		timeBoundStage, err := m.getTimeBoundPipelineStage(r.From, r.To)
 		if err != nil {
 			return nil, err
 		}
 		pipeline = append(pipeline, timeBoundStage)
 	}
-	if m.QueryType == queryTypeTimeseries && m.AutoTimeSort {
-		pipeline = append(pipeline, bson.D{
-			bson.E{
-				Key:   "$sort",
-				Value: bson.D{bson.E{Key: m.TimestampField, Value: 1}},
-			},
-		})
+	if m.QueryType == queryTypeTimeseries && m.AutoBucket {
+		bucketStages, err := m.getAutoBucketPipelineStages(r)
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, bucketStages...)
+	}
+	// $group does not preserve order, so bucketed results must always be sorted
+	if m.QueryType == queryTypeTimeseries && (m.AutoTimeSort || m.AutoBucket) {
+		pipeline = append(pipeline, m.getTimeSortPipelineStage())
+	}
+	if m.QueryType == queryTypeLogs {
+		pipeline = append(pipeline, m.getLogsPipelineStages()...)
 	}
 	return pipeline, nil
 }
+
+func (m *QueryModel) getTimeSortPipelineStage() bson.D {
+	return bson.D{
+		bson.E{
+			Key:   "$sort",
+			Value: bson.D{bson.E{Key: m.TimestampField, Value: 1}},
+		},
+	}
+}
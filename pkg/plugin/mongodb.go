@@ -12,6 +12,7 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/pkg/errors"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -97,36 +98,23 @@ func ConvertGoTimeFormatToMongo(goFormat string) (mongoFormat string, err error)
 	return mongoFormatBuilder.String(), nil
 }
 
-func connect(ctx context.Context, pCtx backend.PluginContext) (client *mongo.Client, err error, internalErr error) {
-	data := datasource{}
-	err = json.Unmarshal([]byte(pCtx.DataSourceInstanceSettings.JSONData), &data.jsonData)
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "Failed to parse data source settings")
-	}
-	secureJsonData, err := json.Marshal(pCtx.DataSourceInstanceSettings.DecryptedSecureJSONData)
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "Failed to remarshal secure data source settings")
-	}
-	err = json.Unmarshal(secureJsonData, &data.secureJsonData)
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "Failed to parse data source settings")
-	}
+func connect(ctx context.Context, data datasource) (*mongo.Client, error) {
 	opts := mongoOpts.Client()
 
 	mongoURL, err := url.Parse(data.URL)
 	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("Invalid Datasource URL %s", data.URL)), nil
+		return nil, errors.Wrap(err, fmt.Sprintf("Invalid Datasource URL %s", data.URL))
 	}
 
 	err = data.applyAuth(mongoURL)
 	if err != nil {
-		return nil, err, nil
+		return nil, err
 	}
 	opts = opts.ApplyURI(mongoURL.String())
 
 	tlsConfig, err := data.getTLS()
 	if err != nil {
-		return nil, err, nil
+		return nil, err
 	}
 	if tlsConfig != nil {
 		opts.SetTLSConfig(tlsConfig)
@@ -135,10 +123,38 @@ func connect(ctx context.Context, pCtx backend.PluginContext) (client *mongo.Cli
 
 	mongoClient, err := mongo.Connect(ctx, opts)
 	if err != nil {
-		return nil, errors.Wrap(err, "Error while connecting to MongoDB"), nil
+		return nil, errors.Wrap(err, "Error while connecting to MongoDB")
+	}
+
+	return mongoClient, nil
+}
+
+func getQueryRange(query backend.DataQuery) queryRange {
+	return queryRange{
+		From:          query.TimeRange.From,
+		To:            query.TimeRange.To,
+		Interval:      query.Interval,
+		MaxDataPoints: query.MaxDataPoints,
 	}
+}
+
+// errDisposed is returned for queries and streams started after the datasource instance was disposed
+var errDisposed = errors.New("Datasource has been disposed, its settings may have changed")
 
-	return mongoClient, nil, nil
+// getClient returns the pooled client owned by this instance, or the reason one could not be created
+func (d *MongoDBDatasource) getClient() (*mongo.Client, error) {
+	if d.connectErr != nil {
+		return nil, d.connectErr
+	}
+	d.clientLock.RLock()
+	defer d.clientLock.RUnlock()
+	if d.disposed {
+		return nil, errDisposed
+	}
+	if d.client == nil {
+		return nil, fmt.Errorf("Datasource is not connected")
+	}
+	return d.client, nil
 }
 
 func (d *MongoDBDatasource) query(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
@@ -157,73 +173,201 @@ func (d *MongoDBDatasource) query(ctx context.Context, pCtx backend.PluginContex
 
 	log.DefaultLogger.Debug("Query Model Parsed", "QueryModel", qm)
 
-	pipeline, err := qm.getPipeline(query.TimeRange.From, query.TimeRange.To)
+	r := getQueryRange(query)
+	err = qm.validate(r)
 	if err != nil {
-		response.Error = errors.Wrap(err, "Failed to produce final pipeline")
+		response.Error = err
 		return response
 	}
 
-	log.DefaultLogger.Debug("Effective pipeline", "pipeline", pipeline)
-
-	mongoClient, err, internalErr := connect(ctx, pCtx)
-	if internalErr != nil {
-		response.Error = errors.Wrap(internalErr, "Internal failure while connecting to mongo")
-		return response
-	}
+	mongoClient, err := d.getClient()
 	if err != nil {
 		response.Error = errors.Wrap(err, "Failed to connect to mongo")
 		return response
 	}
-	defer mongoClient.Disconnect(ctx)
 
-	collection := mongoClient.Database(qm.Database).Collection(qm.Collection)
+	log.DefaultLogger.Info("Querying MongoDB", "context", pCtx, "query", query)
+	response = qm.run(ctx, mongoClient, r)
+
+	log.DefaultLogger.Debug("query finished", "context", pCtx, "query", query, "response", response)
+	return response
+}
 
-	log.DefaultLogger.Info("Querying MongoDB", "context", pCtx, "query", query, "pipeline", pipeline)
-	cursor, err := collection.Aggregate(ctx, pipeline)
+// validate builds the arguments a query would be sent with, without sending it,
+// so that mistakes in the query are reported even when MongoDB cannot be reached
+func (m *QueryModel) validate(r queryRange) error {
+	var err error
+	switch m.QueryType {
+	case queryTypeReplicaSet, queryTypeOplog, queryTypeProfiler, queryTypeServerStatus, queryTypeIndexStats, queryTypeCurrentOp:
+		return nil
+	case queryTypeCount:
+		_, err = m.getFilter(r)
+		err = errors.Wrap(err, "Failed to produce final filter")
+	case queryTypeDistinct:
+		if m.DistinctField == "" {
+			return errors.New("Distinct Field must be specified")
+		}
+		_, err = m.getFilter(r)
+		err = errors.Wrap(err, "Failed to produce final filter")
+	case queryTypeCommand:
+		_, err = m.getCommand(r)
+	default:
+		_, err = m.getCursorArgs(r)
+	}
+	return err
+}
+
+// run executes a query over a time range, and converts its results to frames
+func (m *QueryModel) run(ctx context.Context, mongoClient *mongo.Client, r queryRange) backend.DataResponse {
+	response := backend.DataResponse{}
+
+	// These query types produce more than one kind of frame
+	switch m.QueryType {
+	case queryTypeReplicaSet:
+		return runReplicaSet(ctx, mongoClient)
+	case queryTypeOplog:
+		return m.runOplog(ctx, mongoClient, r)
+	case queryTypeProfiler:
+		return m.runProfiler(ctx, mongoClient.Database(m.Database), r)
+	}
+
+	collection := mongoClient.Database(m.Database).Collection(m.Collection)
+
+	var source documentSource
+	var err error
+	switch m.QueryType {
+	case queryTypeCount:
+		source, err = m.runCount(ctx, collection, r)
+	case queryTypeDistinct:
+		source, err = m.runDistinct(ctx, collection, r)
+	case queryTypeCommand:
+		source, err = m.runCommand(ctx, mongoClient.Database(m.Database), r)
+	case queryTypeServerStatus:
+		source, err = m.runServerStatus(ctx, mongoClient)
+	case queryTypeIndexStats:
+		source, err = m.runIndexStats(ctx, mongoClient.Database(m.Database))
+	case queryTypeCurrentOp:
+		source, err = m.runCurrentOp(ctx, mongoClient)
+	default:
+		source, err = m.openCursor(ctx, collection, r)
+	}
 	if err != nil {
-		response.Error = errors.Wrap(err, "Failed to send query to mongo")
+		response.Error = err
 		return response
 	}
+	if cursor, isCursor := source.(*mongo.Cursor); isCursor {
+		defer cursor.Close(ctx)
+	}
+
+	return readCursor(ctx, m, source)
+}
+
+// cursorArgs are the arguments of an aggregate() or find() call
+type cursorArgs struct {
+	mode     queryMode
+	pipeline mongo.Pipeline
+	filter   bson.D
+	findOpts *mongoOpts.FindOptions
+}
+
+// getCursorArgs produces the arguments for the method selected by the query mode
+func (m *QueryModel) getCursorArgs(r queryRange) (*cursorArgs, error) {
+	err := m.checkArrayExpansion()
+	if err != nil {
+		return nil, err
+	}
+	args := cursorArgs{mode: m.getQueryMode()}
+	switch args.mode {
+	case queryModeAggregate:
+		args.pipeline, err = m.getPipeline(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to produce final pipeline")
+		}
+	case queryModeFind:
+		args.filter, args.findOpts, err = m.getFindArgs(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to produce final find arguments")
+		}
+	default:
+		return nil, fmt.Errorf("Query mode must be one of: %s, %s", queryModeAggregate, queryModeFind)
+	}
+	return &args, nil
+}
+
+// openCursor executes a query using the method selected by its query mode
+func (m *QueryModel) openCursor(ctx context.Context, collection *mongo.Collection, r queryRange) (documentSource, error) {
+	args, err := m.getCursorArgs(r)
+	if err != nil {
+		return nil, err
+	}
+	var cursor *mongo.Cursor
+	if args.mode == queryModeFind {
+		log.DefaultLogger.Debug("Effective find", "filter", args.filter, "options", args.findOpts)
+		cursor, err = collection.Find(ctx, args.filter, args.findOpts)
+	} else {
+		log.DefaultLogger.Debug("Effective pipeline", "pipeline", args.pipeline)
+		cursor, err = collection.Aggregate(ctx, args.pipeline)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to send query to mongo")
+	}
+	return cursor, nil
+}
+
+// readCursor converts the results of a query to frames
+func readCursor(ctx context.Context, qm *QueryModel, cursor documentSource) backend.DataResponse {
+	response := backend.DataResponse{}
+	var err error
 
 	buffered := bufferedCursor{
-		Cursor: cursor,
+		Cursor:       cursor,
+		flattenDepth: qm.getFlattenDepth(),
 	}
 
 	var fields []field
+	var notices []data.Notice
 
-	if qm.SchemaInference {
+	if qm.usesSchemaInference() {
+		inferenceDepth := qm.getSchemaInferenceDepth()
 		buffering := bufferingCursor{
-			Cursor: cursor,
-			buffer: make([]timestepDocument, 0, qm.SchemaInferenceDepth),
+			Cursor:       cursor,
+			buffer:       make([]bufferedDocument, 0),
+			flattenDepth: qm.getFlattenDepth(),
 		}
 
-		ignored := make(map[string]struct{}, 1+len(qm.LabelFields))
-		if qm.QueryType == queryTypeTimeseries {
-			ignored[qm.TimestampField] = struct{}{}
-			for _, name := range qm.LabelFields {
-				ignored[name] = struct{}{}
-			}
-		}
+		ignored := qm.getInferenceIgnoredFields()
 
-		state := NewSchemaInference(ignored)
+		state := NewSchemaInference(ignored, qm.usesFallbackToString())
 
-		doc, more, err := buffering.Next(ctx)
-		for len(buffering.buffer) < qm.SchemaInferenceDepth && more {
-			err = state.updateDoc(doc)
-			if err != nil {
+		expander := qm.getArrayExpander()
+		doc, keys, more, err := buffering.Next(ctx)
+		for more {
+			if expander == nil {
+				err = state.updateDoc(doc, keys)
+			} else {
+				rows, rowKeys := expander.expand(doc, keys)
+				for ix := range rows {
+					err = state.updateDoc(rows[ix], rowKeys[ix])
+					if err != nil {
+						break
+					}
+				}
+			}
+			if err != nil || len(buffering.buffer) >= inferenceDepth {
 				break
 			}
 
-			doc, more, err = buffering.Next(ctx)
+			doc, keys, more, err = buffering.Next(ctx)
 		}
 		if err != nil {
 			response.Error = errors.Wrap(err, "Schema Inference Failed")
 			return response
 		}
 		fields = state.finish()
+		notices = state.notices()
 		log.DefaultLogger.Debug(
 			"Inferred schema",
-			"requestedDocs", qm.SchemaInferenceDepth,
+			"requestedDocs", inferenceDepth,
 			"bufferedDocs", len(buffering.buffer),
 			"fields", fields,
 			"ignored", ignored,
@@ -248,20 +392,21 @@ func (d *MongoDBDatasource) query(ctx context.Context, pCtx backend.PluginContex
 		"model", resolvedModel,
 	)
 
-	parser := resultParser{
-		frames: map[string]*data.Frame{},
-		model:  resolvedModel,
+	parser := newResultParser(resolvedModel)
+	parser.expander = qm.getArrayExpander()
+	if qm.usesSchemaInference() {
+		parser.enableSchemaRevision(qm.getInferenceIgnoredFields(), qm.usesFallbackToString())
 	}
 
 	docCount := 0
-	doc, more, decodeErr, err := buffered.Next(ctx)
+	doc, keys, more, decodeErr, err := buffered.Next(ctx)
 	for more {
-		err = parser.parseQueryResultDocument(doc)
+		err = parser.parseQueryResultDocument(doc, keys)
 		if err != nil {
 			response.Error = fmt.Errorf("Failed to convert document number %d: %s, %v", docCount, err, doc)
 			return response
 		}
-		doc, more, decodeErr, err = buffered.Next(ctx)
+		doc, keys, more, decodeErr, err = buffered.Next(ctx)
 		docCount++
 	}
 	if err != nil {
@@ -276,23 +421,29 @@ func (d *MongoDBDatasource) query(ctx context.Context, pCtx backend.PluginContex
 	log.DefaultLogger.Info(fmt.Sprintf("Processed %d documents", docCount))
 
 	// add the frames to the response.
-	response.Frames = make([]*data.Frame, 0, len(parser.frames))
-	for _, frame := range parser.frames {
-		response.Frames = append(response.Frames, frame)
+	response.Frames = parser.getFrames(qm.SortByLabels)
+	if parser.firstRevision != 0 {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text: fmt.Sprintf(
+				"The inferred schema was revised at document number %d. Increase the Schema Inference Depth past this document to avoid revising the schema",
+				parser.firstRevision,
+			),
+		})
+	}
+	if len(notices) != 0 {
+		for _, frame := range response.Frames {
+			frame.AppendNotices(notices...)
+		}
 	}
 
-	log.DefaultLogger.Debug("query finished", "context", pCtx, "query", query, "response", response)
 	return response
 }
 
 func (d *MongoDBDatasource) ping(ctx context.Context, req *backend.CheckHealthRequest) error {
-	mongoClient, err, internalErr := connect(ctx, req.PluginContext)
-	if internalErr != nil {
-		return errors.Wrap(err, "Failed to connect to mongo")
-	}
+	mongoClient, err := d.getClient()
 	if err != nil {
 		return err
 	}
-	defer mongoClient.Disconnect(ctx)
 	return mongoClient.Ping(ctx, nil)
 }
@@ -3,6 +3,10 @@ package plugin_test
 import (
 	"time"
 
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonprim "go.mongodb.org/mongo-driver/bson/primitive"
+
 	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -16,3 +20,303 @@ var _ = Describe("ConvertGoTimeFormatToMongo", func() {
 		Expect(converted).To(Equal("%b %d %H:%M:%S %z %Y"))
 	})
 })
+
+var _ = Describe("LookupPath", func() {
+	doc := map[string]interface{}{
+		"top":    int32(1),
+		"a.b":    "literal",
+		"nested": bson.D{{Key: "inner", Value: bson.M{"value": "deep"}}},
+		"readings": bson.A{
+			bson.D{{Key: "value", Value: float64(1.5)}},
+			bson.D{{Key: "value", Value: float64(2.5)}},
+		},
+	}
+
+	DescribeTable("should resolve",
+		func(path string, expected interface{}, found bool) {
+			actual, ok := plugin.LookupPath(doc, path)
+			Expect(ok).To(Equal(found))
+			if !found {
+				Expect(actual).To(BeNil())
+				return
+			}
+			Expect(actual).To(Equal(expected))
+		},
+		Entry("a top-level key", "top", int32(1), true),
+		Entry("a key containing a dot", "a.b", "literal", true),
+		Entry("a nested document", "nested.inner.value", "deep", true),
+		Entry("an array element", "readings.1.value", float64(2.5), true),
+		Entry("an array index out of range", "readings.2.value", nil, false),
+		Entry("a missing key", "nested.missing", nil, false),
+	)
+})
+
+// fieldNames returns the names of the fields of a frame, in order
+func fieldNames(frame *data.Frame) []string {
+	names := make([]string, len(frame.Fields))
+	for ix, f := range frame.Fields {
+		names[ix] = f.Name
+	}
+	return names
+}
+
+var _ = Describe("Schema revision", func() {
+	qm := func() *plugin.QueryModel {
+		return &plugin.QueryModel{
+			QueryType:            "Table",
+			SchemaInference:      true,
+			SchemaInferenceDepth: 1,
+		}
+	}
+
+	It("Should add new fields in document order", func() {
+		res := plugin.ReadDocuments(qm(), []bson.D{
+			{{Key: "b", Value: "first"}},
+			{{Key: "b", Value: "second"}, {Key: "z", Value: int32(1)}, {Key: "a", Value: int32(2)}},
+		})
+		Expect(res.Error).ToNot(HaveOccurred())
+		Expect(res.Frames).To(HaveLen(1))
+		Expect(fieldNames(res.Frames[0])).To(Equal([]string{"b", "z", "a"}))
+		Expect(res.Frames[0].Fields[1].At(0)).To(BeNil())
+	})
+
+	It("Should make absent fields nullable", func() {
+		res := plugin.ReadDocuments(qm(), []bson.D{
+			{{Key: "a", Value: int32(1)}, {Key: "b", Value: "x"}},
+			{{Key: "a", Value: int32(2)}},
+		})
+		Expect(res.Error).ToNot(HaveOccurred())
+		Expect(res.Frames[0].Fields[1].Type()).To(Equal(data.FieldTypeNullableString))
+		Expect(res.Frames[0].Fields[1].At(1)).To(BeNil())
+	})
+
+	It("Should widen fields which appear with a wider type", func() {
+		res := plugin.ReadDocuments(qm(), []bson.D{
+			{{Key: "a", Value: int32(1)}},
+			{{Key: "a", Value: float64(2.5)}},
+		})
+		Expect(res.Error).ToNot(HaveOccurred())
+		Expect(res.Frames[0].Fields[0].Type()).To(Equal(data.FieldTypeFloat64))
+		Expect(res.Frames[0].Fields[0].At(0)).To(Equal(float64(1)))
+		Expect(res.Frames[0].Fields[0].At(1)).To(Equal(float64(2.5)))
+	})
+
+	It("Should report the source document which caused a revision", func() {
+		model := qm()
+		model.ExpandArrayField = "samples"
+		res := plugin.ReadDocuments(model, []bson.D{
+			{{Key: "samples", Value: bson.A{
+				bson.D{{Key: "v", Value: int32(1)}},
+				bson.D{{Key: "v", Value: int32(2)}},
+				bson.D{{Key: "v", Value: int32(3)}},
+			}}},
+			{{Key: "extra", Value: "x"}, {Key: "samples", Value: bson.A{bson.D{{Key: "v", Value: int32(4)}}}}},
+		})
+		Expect(res.Error).ToNot(HaveOccurred())
+		Expect(res.Frames[0].Rows()).To(Equal(4))
+		Expect(res.Frames[0].Meta.Notices).To(ContainElement(HaveField("Text", ContainSubstring("document number 2"))))
+	})
+})
+
+var _ = Describe("Schema inference", func() {
+	It("Should order fields by the document they first appear in, then by their order in it", func() {
+		res := plugin.ReadDocuments(&plugin.QueryModel{
+			QueryType:            "Table",
+			SchemaInference:      true,
+			SchemaInferenceDepth: 10,
+		}, []bson.D{
+			{{Key: "name", Value: "a"}, {Key: "count", Value: int32(1)}},
+			{{Key: "count", Value: int32(2)}, {Key: "name", Value: "b"}, {Key: "zeta", Value: true}},
+			{{Key: "alpha", Value: "late"}, {Key: "name", Value: "c"}, {Key: "mid", Value: int32(3)}},
+			{{Key: "zeta", Value: false}, {Key: "count", Value: int32(4)}},
+		})
+		Expect(res.Error).ToNot(HaveOccurred())
+		Expect(res.Frames).To(HaveLen(1))
+		Expect(fieldNames(res.Frames[0])).To(Equal([]string{"name", "count", "zeta", "alpha", "mid"}))
+	})
+
+	It("Should order fields the same way on every run", func() {
+		qm := &plugin.QueryModel{QueryType: "Table", SchemaInference: true, SchemaInferenceDepth: 10}
+		docs := []bson.D{{
+			{Key: "e", Value: int32(1)}, {Key: "d", Value: int32(1)}, {Key: "c", Value: int32(1)},
+			{Key: "b", Value: int32(1)}, {Key: "a", Value: int32(1)},
+		}}
+		for run := 0; run < 20; run++ {
+			res := plugin.ReadDocuments(qm, docs)
+			Expect(res.Error).ToNot(HaveOccurred())
+			Expect(fieldNames(res.Frames[0])).To(Equal([]string{"e", "d", "c", "b", "a"}))
+		}
+	})
+
+	It("Should not infer the documents containing nested label and timestamp fields", func() {
+		res := plugin.ReadDocuments(&plugin.QueryModel{
+			QueryType:            "Timeseries",
+			TimestampField:       "metadata.time",
+			LabelFields:          []string{"metadata.sensorID"},
+			SchemaInference:      true,
+			SchemaInferenceDepth: 1,
+		}, []bson.D{
+			{
+				{Key: "metadata", Value: bson.D{
+					{Key: "time", Value: bsonprim.NewDateTimeFromTime(now)},
+					{Key: "sensorID", Value: "a"},
+				}},
+				{Key: "measurement", Value: float64(1)},
+			},
+			{
+				{Key: "metadata", Value: bson.D{
+					{Key: "time", Value: bsonprim.NewDateTimeFromTime(now)},
+					{Key: "sensorID", Value: "b"},
+				}},
+				{Key: "measurement", Value: float64(2)},
+			},
+		})
+		Expect(res.Error).ToNot(HaveOccurred())
+		Expect(res.Frames).To(HaveLen(2))
+		for _, frame := range res.Frames {
+			Expect(fieldNames(frame)).To(Equal([]string{"metadata.time", "measurement"}))
+		}
+		Expect(res.Frames[1].Fields[1].Labels).To(Equal(data.Labels{"metadata.sensorID": "b"}))
+	})
+})
+
+var _ = Describe("FlattenDocument", func() {
+	nested := bson.D{
+		{Key: "a", Value: int32(1)},
+		{Key: "metadata", Value: bson.D{
+			{Key: "sensorID", Value: "x"},
+			{Key: "location", Value: bson.D{{Key: "lat", Value: 1.5}}},
+		}},
+		{Key: "tags", Value: bson.A{"t"}},
+	}
+
+	DescribeTable("should flatten",
+		func(depth int, expected map[string]interface{}, expectedKeys []string) {
+			doc, keys := plugin.FlattenDocument(nested, depth)
+			Expect(doc).To(Equal(expected))
+			Expect(keys).To(Equal(expectedKeys))
+		},
+		Entry("nothing at depth 0", 0,
+			map[string]interface{}{"a": int32(1), "metadata": nested[1].Value, "tags": bson.A{"t"}},
+			[]string{"a", "metadata", "tags"},
+		),
+		Entry("one level at depth 1", 1,
+			map[string]interface{}{
+				"a":                 int32(1),
+				"metadata.sensorID": "x",
+				"metadata.location": bson.D{{Key: "lat", Value: 1.5}},
+				"tags":              bson.A{"t"},
+			},
+			[]string{"a", "metadata.sensorID", "metadata.location", "tags"},
+		),
+		Entry("every level at a greater depth", 10,
+			map[string]interface{}{
+				"a":                     int32(1),
+				"metadata.sensorID":     "x",
+				"metadata.location.lat": 1.5,
+				"tags":                  bson.A{"t"},
+			},
+			[]string{"a", "metadata.sensorID", "metadata.location.lat", "tags"},
+		),
+	)
+})
+
+var _ = Describe("ExpandArray", func() {
+	samples := bson.A{
+		bson.D{{Key: "t", Value: int32(1)}, {Key: "v", Value: 1.5}},
+		bson.D{{Key: "t", Value: int32(2)}, {Key: "v", Value: 2.5}},
+	}
+
+	It("Should expand a top-level array into rows with the parent fields", func() {
+		rows, keys := plugin.ExpandArray("samples", map[string]interface{}{
+			"sensor":  "a",
+			"samples": samples,
+		}, []string{"sensor", "samples"})
+		Expect(rows).To(Equal([]map[string]interface{}{
+			{"sensor": "a", "t": int32(1), "v": 1.5},
+			{"sensor": "a", "t": int32(2), "v": 2.5},
+		}))
+		Expect(keys).To(Equal([][]string{{"sensor", "t", "v"}, {"sensor", "t", "v"}}))
+	})
+
+	It("Should remove a nested array from its parent document", func() {
+		rows, keys := plugin.ExpandArray("data.samples", map[string]interface{}{
+			"data": bson.D{{Key: "sensor", Value: "a"}, {Key: "samples", Value: samples}},
+		}, []string{"data"})
+		Expect(rows).To(HaveLen(2))
+		Expect(rows[0]).To(Equal(map[string]interface{}{
+			"data": bson.D{{Key: "sensor", Value: "a"}},
+			"t":    int32(1),
+			"v":    1.5,
+		}))
+		Expect(keys[0]).To(Equal([]string{"data", "t", "v"}))
+	})
+
+	It("Should store elements which are not documents under the array field", func() {
+		rows, _ := plugin.ExpandArray("values", map[string]interface{}{
+			"values": bson.A{int32(1), int32(2)},
+		}, nil)
+		Expect(rows).To(Equal([]map[string]interface{}{{"values": int32(1)}, {"values": int32(2)}}))
+	})
+
+	It("Should leave documents without the array unchanged", func() {
+		doc := map[string]interface{}{"sensor": "a"}
+		rows, keys := plugin.ExpandArray("samples", doc, []string{"sensor"})
+		Expect(rows).To(Equal([]map[string]interface{}{doc}))
+		Expect(keys).To(Equal([][]string{{"sensor"}}))
+	})
+})
+
+var _ = Describe("Frame order", func() {
+	// series produces one document for each label value, in order
+	series := func(values ...string) []bson.D {
+		docs := make([]bson.D, len(values))
+		for ix, value := range values {
+			docs[ix] = bson.D{
+				{Key: "ts", Value: bsonprim.NewDateTimeFromTime(now)},
+				{Key: "host", Value: value},
+				{Key: "value", Value: float64(ix)},
+			}
+		}
+		return docs
+	}
+	// hosts returns the host label of each frame, in order
+	hosts := func(frames data.Frames) []string {
+		labels := make([]string, len(frames))
+		for ix, frame := range frames {
+			labels[ix] = frame.Fields[1].Labels["host"]
+		}
+		return labels
+	}
+
+	DescribeTable("should return frames",
+		func(sortByLabels bool, values []string, expected []string) {
+			res := plugin.ReadDocuments(&plugin.QueryModel{
+				QueryType:            "Timeseries",
+				TimestampField:       "ts",
+				LabelFields:          []string{"host"},
+				SchemaInference:      true,
+				SchemaInferenceDepth: 20,
+				SortByLabels:         sortByLabels,
+			}, series(values...))
+			Expect(res.Error).ToNot(HaveOccurred())
+			Expect(hosts(res.Frames)).To(Equal(expected))
+		},
+		Entry("in the order they first appear by default", false,
+			[]string{"c", "a", "b", "a"},
+			[]string{"c", "a", "b"},
+		),
+		Entry("sorted by label value", true,
+			[]string{"c", "a", "b"},
+			[]string{"a", "b", "c"},
+		),
+		Entry("sorted by numeric label values as numbers", true,
+			[]string{"10", "9", "100", "1.5"},
+			[]string{"1.5", "9", "10", "100"},
+		),
+		Entry("sorted by numeric label values before other label values", true,
+			[]string{"b", "10", "9", "a"},
+			[]string{"9", "10", "a", "b"},
+		),
+	)
+})
@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	oplogDatabase   = "local"
+	oplogCollection = "oplog.rs"
+)
+
+// getOplogQueryModel produces the timeseries query which counts the entries in the oplog
+// for each namespace and operation type, in automatic time buckets.
+// No-op entries are written periodically by idle replica sets, and are not counted.
+func (m *QueryModel) getOplogQueryModel() QueryModel {
+	return QueryModel{
+		Database:             oplogDatabase,
+		Collection:           oplogCollection,
+		QueryType:            queryTypeTimeseries,
+		QueryMode:            queryModeAggregate,
+		TimestampField:       "ts",
+		TimestampType:        timestampTypeTimestamp,
+		LabelFields:          []string{"ns", "op"},
+		LegendFormat:         m.LegendFormat,
+		SortByLabels:         true,
+		ValueFields:          []string{"count"},
+		ValueFieldTypes:      []string{data.FieldTypeInt64.ItemTypeString()},
+		AutoTimeBound:        true,
+		AutoTimeBoundAtStart: true,
+		Aggregation:          `[{"$match": {"op": {"$ne": "n"}}}]`,
+		AutoBucket:           true,
+		AutoBucketReducers:   []string{bucketReducerCount},
+	}
+}
+
+// runOplog counts the entries in the oplog within the time range, and adds a frame with the current oplog window
+func (m *QueryModel) runOplog(ctx context.Context, client *mongo.Client, r queryRange) backend.DataResponse {
+	response := backend.DataResponse{}
+	oplog := client.Database(oplogDatabase).Collection(oplogCollection)
+
+	counts := m.getOplogQueryModel()
+	source, err := counts.openCursor(ctx, oplog, r)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	if cursor, isCursor := source.(*mongo.Cursor); isCursor {
+		defer cursor.Close(ctx)
+	}
+
+	response = readCursor(ctx, &counts, source)
+	if response.Error != nil {
+		return response
+	}
+
+	window, err := getOplogWindowFrame(ctx, oplog)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	response.Frames = append(response.Frames, window)
+	return response
+}
+
+// getOplogWindowFrame produces a frame with the times of the first and last entries in the oplog,
+// and the length of time between them, which is how long a secondary can be offline before it must resync
+func getOplogWindowFrame(ctx context.Context, oplog *mongo.Collection) (*data.Frame, error) {
+	first, err := getOplogEntryTime(ctx, oplog, 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to find the first oplog entry")
+	}
+	last, err := getOplogEntryTime(ctx, oplog, -1)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to find the last oplog entry")
+	}
+	return data.NewFrame("window",
+		data.NewField("first", nil, []time.Time{first}),
+		data.NewField("last", nil, []time.Time{last}),
+		data.NewField("windowSeconds", nil, []float64{last.Sub(first).Seconds()}),
+	), nil
+}
+
+// getOplogEntryTime returns the time of the first entry in the oplog in natural order, or the last if order is -1
+func getOplogEntryTime(ctx context.Context, oplog *mongo.Collection, order int) (time.Time, error) {
+	opts := mongoOpts.FindOne().
+		SetSort(bson.D{bson.E{Key: "$natural", Value: order}}).
+		SetProjection(bson.D{bson.E{Key: "ts", Value: 1}})
+	var entry struct {
+		Ts bsonPrim.Timestamp `bson:"ts"`
+	}
+	err := oplog.FindOne(ctx, bson.D{}, opts).Decode(&entry)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(entry.Ts.T), 0), nil
+}
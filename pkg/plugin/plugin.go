@@ -2,10 +2,15 @@ package plugin
 
 import (
 	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // Make sure MongoDBDatasource implements required interfaces. This is important to do
@@ -20,24 +25,70 @@ import (
 var (
 	_ backend.QueryDataHandler      = (*MongoDBDatasource)(nil)
 	_ backend.CheckHealthHandler    = (*MongoDBDatasource)(nil)
+	_ backend.StreamHandler         = (*MongoDBDatasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*MongoDBDatasource)(nil)
 )
 
+// disposeTimeout is how long Dispose will wait for in-use connections to be returned to the pool
+const disposeTimeout = 30 * time.Second
+
 // NewMongoDBDatasource creates a new datasource instance.
-func NewMongoDBDatasource(_ backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
-	return &MongoDBDatasource{}, nil
+// The settings are parsed once, and a pooled client is created which is shared by all queries
+// until the instance is disposed. Errors in the connection settings (bad URL, bad TLS material)
+// do not fail instance creation, and are instead reported by each query and health check
+func NewMongoDBDatasource(settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+	data, err := parseDatasource(settings)
+	if err != nil {
+		return nil, err
+	}
+	// mongo.Connect does not perform any I/O, connections are established lazily by the pool
+	client, err := connect(context.Background(), data)
+	return &MongoDBDatasource{
+		settings:   data,
+		client:     client,
+		connectErr: err,
+	}, nil
 }
 
 // MongoDBDatasource is a datasource which can respond to data queries, reports
 // its health and has streaming skills.
 type MongoDBDatasource struct {
+	settings datasource
+	// clientLock guards client, which is replaced with nil when the instance is disposed
+	clientLock sync.RWMutex
+	client     *mongo.Client
+	disposed   bool
+	connectErr error
+	// resumeTokens contains the resume token of the last change event sent to each running stream path,
+	// so that streams which are retried after a failure do not drop events
+	resumeTokens sync.Map
+	// lastSentTimestamps contains the timestamp of the last row of each frame sent to each running polling stream path
+	lastSentTimestamps sync.Map
+	// tailPositions contains the tailPosition of each running tail stream path
+	tailPositions sync.Map
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
 // created. As soon as datasource settings change detected by SDK old datasource instance will
 // be disposed and a new one will be created using NewMongoDBDatasource factory function.
+// Queries and streams which start after this report that the datasource was disposed, and those
+// already in flight are given disposeTimeout to finish before their connections are closed.
 func (d *MongoDBDatasource) Dispose() {
-	// Clean up datasource instance resources.
+	d.clientLock.Lock()
+	client := d.client
+	d.client = nil
+	d.disposed = true
+	d.clientLock.Unlock()
+
+	if client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), disposeTimeout)
+	defer cancel()
+	err := client.Disconnect(ctx)
+	if err != nil {
+		log.DefaultLogger.Warn("Failed to cleanly disconnect from MongoDB", "error", err)
+	}
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -47,19 +98,57 @@ func (d *MongoDBDatasource) Dispose() {
 func (d *MongoDBDatasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	log.DefaultLogger.Info("QueryData called", "request", req)
 
-	// create response struct
+	response := runQueries(ctx, req.Queries, d.settings.getMaxConcurrentQueries(), func(ctx context.Context, q backend.DataQuery) backend.DataResponse {
+		return d.query(ctx, req.PluginContext, q)
+	})
+	return response, nil
+}
+
+// runQueries executes the queries concurrently, at most limit at a time.
+// Each query receives the request context, so cancelling the request
+// aborts every cursor that is still in flight, and fails the queries which have not started.
+// A panic while running a query fails only that query.
+func runQueries(ctx context.Context, queries []backend.DataQuery, limit int, run func(context.Context, backend.DataQuery) backend.DataResponse) *backend.QueryDataResponse {
 	response := backend.NewQueryDataResponse()
 
-	// loop over queries and execute them individually.
-	for _, q := range req.Queries {
-		res := d.query(ctx, req.PluginContext, q)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, limit)
+	for _, q := range queries {
+		q := q
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var res backend.DataResponse
+			select {
+			case slots <- struct{}{}:
+				res = runQuery(ctx, q, run)
+				<-slots
+			case <-ctx.Done():
+				res.Error = ctx.Err()
+			}
 
-		// save the response in a hashmap
-		// based on with RefID as identifier
-		response.Responses[q.RefID] = res
+			// save the response in a hashmap
+			// based on with RefID as identifier
+			lock.Lock()
+			defer lock.Unlock()
+			response.Responses[q.RefID] = res
+		}()
 	}
+	wg.Wait()
 
-	return response, nil
+	return response
+}
+
+// runQuery executes a single query, converting a panic into an error response
+func runQuery(ctx context.Context, q backend.DataQuery, run func(context.Context, backend.DataQuery) backend.DataResponse) (res backend.DataResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.DefaultLogger.Error("Query panicked", "refID", q.RefID, "panic", r, "stack", string(debug.Stack()))
+			res = backend.DataResponse{Error: fmt.Errorf("Query %s failed unexpectedly: %v", q.RefID, r)}
+		}
+	}()
+	return run(ctx, q)
 }
 
 // CheckHealth handles health checks sent from Grafana to the plugin.
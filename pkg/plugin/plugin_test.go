@@ -3,6 +3,7 @@ package plugin_test
 import (
 	"context"
 	"encoding/json"
+	"sync"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -41,6 +42,233 @@ var _ = Describe("QueryData", func() {
 		Expect(err).ToNot(HaveOccurred())
 		Expect(resp.Responses).To(HaveLen(1), "QueryData must return a response")
 	})
+
+	It("Should return a response for every RefID", func() {
+		ds := plugin.MongoDBDatasource{}
+
+		queries := make([]backend.DataQuery, 10)
+		for ix := range queries {
+			queries[ix].RefID = string(rune('A' + ix))
+		}
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{Queries: queries})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Responses).To(HaveLen(len(queries)))
+		for _, q := range queries {
+			Expect(resp.Responses).To(HaveKey(q.RefID))
+		}
+	})
+
+	It("Should report invalid queries before connecting", func() {
+		ds := plugin.MongoDBDatasource{}
+
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{
+				{RefID: "A", JSON: []byte(`{"queryType": "Table", "queryMode": "Find", "filter": "{not json"}`)},
+				{RefID: "B", JSON: []byte(`{"queryType": "Distinct"}`)},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Responses["A"].Error).To(MatchError(ContainSubstring("filter")))
+		Expect(resp.Responses["B"].Error).To(MatchError(ContainSubstring("Distinct Field")))
+	})
+
+	It("Should reject array expansion with the automatic stages", func() {
+		ds := plugin.MongoDBDatasource{}
+
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{
+				{RefID: "A", JSON: []byte(`{"queryType": "Timeseries", "timestampField": "ts", "expandArrayField": "samples", "autoTimeBound": true}`)},
+				{RefID: "B", JSON: []byte(`{"queryType": "Timeseries", "timestampField": "ts", "expandArrayField": "samples", "autoTimeSort": true}`)},
+				{RefID: "C", JSON: []byte(`{"queryType": "Timeseries", "timestampField": "ts", "expandArrayField": "samples", "autoBucket": true}`)},
+				{RefID: "D", JSON: []byte(`{"queryType": "Timeseries", "queryMode": "Find", "timestampField": "ts", "expandArrayField": "samples", "autoTimeSort": true}`)},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Responses["A"].Error).To(MatchError(ContainSubstring("time bound")))
+		Expect(resp.Responses["B"].Error).To(MatchError(ContainSubstring("time sort")))
+		Expect(resp.Responses["C"].Error).To(MatchError(ContainSubstring("time buckets")))
+		Expect(resp.Responses["D"].Error).To(MatchError(ContainSubstring("time sort")))
+	})
+})
+
+var _ = Describe("RunQueries", func() {
+	refIDs := func(n int) []backend.DataQuery {
+		queries := make([]backend.DataQuery, n)
+		for ix := range queries {
+			queries[ix].RefID = string(rune('A' + ix))
+		}
+		return queries
+	}
+
+	It("Should run at most the limit of queries at a time", func() {
+		var lock sync.Mutex
+		running, maxRunning := 0, 0
+		resp := plugin.RunQueries(context.Background(), refIDs(10), 3, func(ctx context.Context, q backend.DataQuery) backend.DataResponse {
+			lock.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			lock.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			lock.Lock()
+			running--
+			lock.Unlock()
+			return backend.DataResponse{}
+		})
+		Expect(resp.Responses).To(HaveLen(10))
+		Expect(maxRunning).To(Equal(3))
+	})
+
+	It("Should fail the queries which have not started when cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		var once sync.Once
+		var lock sync.Mutex
+		ran := 0
+		resp := plugin.RunQueries(ctx, refIDs(5), 1, func(ctx context.Context, q backend.DataQuery) backend.DataResponse {
+			lock.Lock()
+			ran++
+			lock.Unlock()
+			// The first query to run cancels the batch, and keeps the only slot while the others give up waiting for it
+			once.Do(func() {
+				cancel()
+				time.Sleep(50 * time.Millisecond)
+			})
+			<-ctx.Done()
+			return backend.DataResponse{Error: ctx.Err()}
+		})
+		Expect(ran).To(Equal(1), "queries waiting for a slot must not run after cancellation")
+		Expect(resp.Responses).To(HaveLen(5))
+		for _, res := range resp.Responses {
+			Expect(res.Error).To(MatchError(context.Canceled))
+		}
+	})
+
+	It("Should fail only the query which panicked", func() {
+		resp := plugin.RunQueries(context.Background(), refIDs(3), 2, func(ctx context.Context, q backend.DataQuery) backend.DataResponse {
+			if q.RefID == "B" {
+				panic("boom")
+			}
+			return backend.DataResponse{}
+		})
+		Expect(resp.Responses["A"].Error).ToNot(HaveOccurred())
+		Expect(resp.Responses["B"].Error).To(MatchError(ContainSubstring("boom")))
+		Expect(resp.Responses["C"].Error).ToNot(HaveOccurred())
+	})
+})
+
+var _ = Describe("NewMongoDBDatasource", func() {
+	It("Should reject unparseable settings", func() {
+		_, err := plugin.NewMongoDBDatasource(backend.DataSourceInstanceSettings{JSONData: []byte("not json")})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should defer connection errors to health checks", func() {
+		inst, err := plugin.NewMongoDBDatasource(backend.DataSourceInstanceSettings{
+			JSONData: []byte(`{"url": "not-a-mongodb-url"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		ds := inst.(*plugin.MongoDBDatasource)
+		defer ds.Dispose()
+
+		res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Status).To(Equal(backend.HealthStatusError))
+	})
+})
+
+var _ = Describe("Dispose", func() {
+	It("Should fail queries started after it", func() {
+		inst, err := plugin.NewMongoDBDatasource(backend.DataSourceInstanceSettings{
+			JSONData: []byte(`{"url": "mongodb://localhost:1"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		ds := inst.(*plugin.MongoDBDatasource)
+		ds.Dispose()
+
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{{RefID: "A", JSON: []byte(`{"queryType": "Count"}`)}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Responses["A"].Error).To(MatchError(plugin.ErrDisposed))
+
+		res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Status).To(Equal(backend.HealthStatusError))
+		Expect(res.Message).To(ContainSubstring("disposed"))
+	})
+})
+
+var _ = Describe("SubscribeStream", func() {
+	It("Should accept queries with a stream mode", func() {
+		ds := plugin.MongoDBDatasource{}
+
+		resp, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+			Path: "ChangeStream/8d8da557188abadb",
+			Data: []byte(`{"database": "my_db", "collection": "my_collection", "streamMode": "ChangeStream"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Status).To(Equal(backend.SubscribeStreamStatusOK))
+	})
+
+	It("Should hash non-ASCII queries as UTF-16, in the same way as the frontend", func() {
+		ds := plugin.MongoDBDatasource{}
+
+		resp, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+			Path: "ChangeStream/5032e9b06e3f6118",
+			Data: []byte(`{"collection":"é😀","streamMode":"ChangeStream"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Status).To(Equal(backend.SubscribeStreamStatusOK))
+	})
+
+	DescribeTable("should check that polled queries can use the automatic time bound",
+		func(path string, query string, status backend.SubscribeStreamStatus) {
+			ds := plugin.MongoDBDatasource{}
+
+			resp, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: path, Data: []byte(query)})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.Status).To(Equal(status))
+		},
+		Entry("accepting a timeseries",
+			"Poll/04e7d4ef8894e86f", `{"queryType":"Timeseries","timestampField":"ts","streamMode":"Poll"}`,
+			backend.SubscribeStreamStatusOK,
+		),
+		Entry("accepting server status samples",
+			"Poll/a9715c204a994572", `{"queryType":"ServerStatus","streamMode":"Poll"}`,
+			backend.SubscribeStreamStatusOK,
+		),
+		Entry("rejecting a table",
+			"Poll/b8b1712460beb8e7", `{"queryType":"Table","streamMode":"Poll"}`,
+			backend.SubscribeStreamStatusNotFound,
+		),
+		Entry("rejecting automatic time buckets",
+			"Poll/babd726d8c9ab055", `{"queryType":"Timeseries","timestampField":"ts","autoBucket":true,"streamMode":"Poll"}`,
+			backend.SubscribeStreamStatusNotFound,
+		),
+	)
+
+	It("Should reject paths which do not match their query", func() {
+		ds := plugin.MongoDBDatasource{}
+
+		resp, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+			Path: "ChangeStream/8d8da557188abadb",
+			Data: []byte(`{"database": "other_db", "collection": "my_collection", "streamMode": "ChangeStream"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Status).To(Equal(backend.SubscribeStreamStatusNotFound))
+	})
+
+	It("Should reject queries without a stream mode", func() {
+		ds := plugin.MongoDBDatasource{}
+
+		resp, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+			Path: "abc",
+			Data: []byte(`{"database": "my_db", "collection": "my_collection"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Status).To(Equal(backend.SubscribeStreamStatusNotFound))
+	})
 })
 
 var _ = Describe("ToGrafanaValue", func() {
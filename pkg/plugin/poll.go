@@ -0,0 +1,158 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultPollInterval is how often a polling stream re-runs its query if not specified
+	defaultPollInterval = 10 * time.Second
+	// defaultPollWindow is how far back in time a polling stream queries if not specified
+	defaultPollWindow = 5 * time.Minute
+)
+
+// getPollInterval returns how often a polling stream re-runs its query
+func (m *QueryModel) getPollInterval() time.Duration {
+	if m.PollIntervalSeconds <= 0 {
+		return defaultPollInterval
+	}
+	return time.Duration(m.PollIntervalSeconds) * time.Second
+}
+
+// getPollWindow returns the length of the sliding time range a polling stream queries
+func (m *QueryModel) getPollWindow() time.Duration {
+	if m.PollWindowSeconds <= 0 {
+		return defaultPollWindow
+	}
+	return time.Duration(m.PollWindowSeconds) * time.Second
+}
+
+// enablePollingTimeBound turns on the automatic time bound of a polled query, so that each poll only reads
+// the sliding time range instead of the whole collection. Server status samples have no time range.
+func (m *QueryModel) enablePollingTimeBound() error {
+	if m.QueryType == queryTypeServerStatus {
+		return nil
+	}
+	m.AutoTimeBound = true
+	if !m.usesAutoTimeBound() {
+		return fmt.Errorf("Polling streams are only supported by query types with an automatic time bound, and %s", queryTypeServerStatus)
+	}
+	if m.TimestampField == "" {
+		return errors.New("Polling streams require a Timestamp Field")
+	}
+	// Rows are never revised once sent, so the newest bucket, which is still filling, would never be completed
+	if m.QueryType == queryTypeTimeseries && m.AutoBucket {
+		return errors.New("Polling streams cannot use automatic time buckets")
+	}
+	return nil
+}
+
+// getStreamTimestampField returns the name of the field containing the timestamp of each row of the results
+func (m *QueryModel) getStreamTimestampField() string {
+	if m.QueryType == queryTypeServerStatus {
+		return serverStatusTimeField
+	}
+	return m.TimestampField
+}
+
+// runPollingStream re-runs the query on a fixed interval over a sliding time range ending at the current time,
+// and sends the rows of each frame which are newer than the last row sent for that frame.
+// The automatic time bound is always used, see enablePollingTimeBound.
+// The timestamp of the last row sent is kept while the stream is retried, so that rows are not sent again.
+// The cumulative counters of a ServerStatus query are sent as per-second rates.
+func (d *MongoDBDatasource) runPollingStream(ctx context.Context, path string, qm *QueryModel, sender *backend.StreamSender) error {
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	interval := qm.getPollInterval()
+	window := qm.getPollWindow()
+	timestampField := qm.getStreamTimestampField()
+	lastSentValue, _ := d.lastSentTimestamps.LoadOrStore(path, make(map[string]time.Time))
+	lastSent := lastSentValue.(map[string]time.Time)
+	var rates *serverStatusRates
+	if qm.QueryType == queryTypeServerStatus {
+		rates = newServerStatusRates(qm.getServerStatusMetrics())
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		response := qm.run(ctx, client, queryRange{From: now.Add(-window), To: now, Interval: interval})
+		if response.Error != nil {
+			return response.Error
+		}
+		for _, frame := range response.Frames {
+			if rates != nil {
+				err = rates.apply(frame)
+				if err != nil {
+					return err
+				}
+			}
+			newer, err := getNewerRows(frame, timestampField, lastSent)
+			if err != nil {
+				return err
+			}
+			if newer.Rows() == 0 {
+				continue
+			}
+			err = sender.SendFrame(newer, data.IncludeAll)
+			if err != nil {
+				return errors.Wrap(err, "Failed to send frame")
+			}
+		}
+		log.DefaultLogger.Debug("Polled stream", "frames", len(response.Frames), "lastSent", lastSent)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// getNewerRows filters a frame to the rows with timestamps after the last one sent for a frame of the same name,
+// and records the newest timestamp as sent
+func getNewerRows(frame *data.Frame, timestampField string, lastSent map[string]time.Time) (*data.Frame, error) {
+	_, fieldIx := frame.FieldByName(timestampField)
+	if fieldIx == -1 {
+		return nil, fmt.Errorf("Polling streams require every frame to have the Timestamp Field %s", timestampField)
+	}
+	last := lastSent[frame.Name]
+	newest := last
+	newer, err := frame.FilterRowsByField(fieldIx, func(value interface{}) (bool, error) {
+		var timestamp time.Time
+		switch v := value.(type) {
+		case time.Time:
+			timestamp = v
+		case *time.Time:
+			if v == nil {
+				return false, nil
+			}
+			timestamp = *v
+		default:
+			return false, fmt.Errorf("Timestamp Field %s must be a time, got %#v", timestampField, value)
+		}
+		if !timestamp.After(last) {
+			return false, nil
+		}
+		if timestamp.After(newest) {
+			newest = timestamp
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	lastSent[frame.Name] = newest
+	return newer, nil
+}
@@ -0,0 +1,53 @@
+package plugin_test
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GetNewerRows", func() {
+	at := func(seconds int) time.Time {
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+	atPointer := func(seconds int) *time.Time {
+		t := at(seconds)
+		return &t
+	}
+
+	DescribeTable("should keep",
+		func(timestamps interface{}, lastSent map[string]time.Time, expectedRows int, expectedLast time.Time) {
+			frame := data.NewFrame("series", data.NewField("ts", nil, timestamps))
+			newer, err := plugin.GetNewerRows(frame, "ts", lastSent)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(newer.Rows()).To(Equal(expectedRows))
+			Expect(lastSent["series"]).To(Equal(expectedLast))
+		},
+		Entry("every row of a frame not sent before",
+			[]time.Time{at(1), at(2)}, map[string]time.Time{}, 2, at(2),
+		),
+		Entry("only the rows after the last one sent",
+			[]time.Time{at(1), at(2), at(3)}, map[string]time.Time{"series": at(2)}, 1, at(3),
+		),
+		Entry("no rows if none are newer, keeping the last one sent",
+			[]time.Time{at(1), at(2)}, map[string]time.Time{"series": at(2)}, 0, at(2),
+		),
+		Entry("rows of a frame with a different name",
+			[]time.Time{at(1)}, map[string]time.Time{"other": at(2)}, 1, at(1),
+		),
+		Entry("newer rows with nullable timestamps, skipping absent ones",
+			[]*time.Time{atPointer(1), nil, atPointer(3)}, map[string]time.Time{"series": at(1)}, 1, at(3),
+		),
+	)
+
+	It("Should reject frames without the timestamp field", func() {
+		frame := data.NewFrame("series", data.NewField("value", nil, []float64{1}))
+		_, err := plugin.GetNewerRows(frame, "ts", map[string]time.Time{})
+		Expect(err).To(HaveOccurred())
+	})
+})
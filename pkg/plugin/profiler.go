@@ -0,0 +1,279 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	profilerCollection = "system.profile"
+	// defaultProfilerTopN is the number of shapes in the table if not specified
+	defaultProfilerTopN = 10
+	// defaultProfilerRankBy is the statistic the table is ranked by if not specified
+	defaultProfilerRankBy = "totalMillis"
+)
+
+// profilerIgnoredCommandFields are the fields of a profiled command which describe the session or connection,
+// rather than the operation, and so are not part of its shape
+var profilerIgnoredCommandFields = map[string]struct{}{
+	"lsid":             {},
+	"txnNumber":        {},
+	"autocommit":       {},
+	"startTransaction": {},
+	"$clusterTime":     {},
+	"$db":              {},
+	"$readPreference":  {},
+	"$audit":           {},
+	"$client":          {},
+	"readConcern":      {},
+	"writeConcern":     {},
+	"maxTimeMS":        {},
+	"comment":          {},
+}
+
+// profileEntry is the subset of a document in system.profile used by this plugin
+type profileEntry struct {
+	Ts           time.Time `bson:"ts"`
+	Ns           string    `bson:"ns"`
+	Op           string    `bson:"op"`
+	Command      bson.D    `bson:"command"`
+	Query        bson.D    `bson:"query"`
+	Millis       int64     `bson:"millis"`
+	DocsExamined int64     `bson:"docsExamined"`
+	KeysExamined int64     `bson:"keysExamined"`
+}
+
+// getShape normalizes the command of a profiled operation by replacing its literal values with placeholders,
+// so that operations which differ only by those values have the same shape
+func (e *profileEntry) getShape() (string, error) {
+	var redacted interface{}
+	if len(e.Command) == 0 {
+		// Servers before 3.6 record queries instead of commands, which have no command name to keep
+		redacted = redactLiterals(e.Query)
+	} else {
+		relevant := make(bson.D, 0, len(e.Command))
+		for _, elem := range e.Command {
+			if _, ignored := profilerIgnoredCommandFields[elem.Key]; ignored {
+				continue
+			}
+			relevant = append(relevant, elem)
+		}
+		redacted = redactCommand(relevant)
+	}
+	shape, err := bson.MarshalExtJSON(redacted, false, false)
+	if err != nil {
+		return "", err
+	}
+	return string(shape), nil
+}
+
+// profileShape is the totals for all of the profiled operations with the same shape
+type profileShape struct {
+	shape        string
+	ns           string
+	op           string
+	count        int64
+	millis       int64
+	docsExamined int64
+	keysExamined int64
+}
+
+// getProfilerTimeseriesQueryModel produces the query used to parse the total time spent on each shape in each bucket
+func (m *QueryModel) getProfilerTimeseriesQueryModel() QueryModel {
+	return QueryModel{
+		QueryType:       queryTypeTimeseries,
+		TimestampField:  "ts",
+		LabelFields:     []string{"ns", "shape"},
+		LegendFormat:    m.LegendFormat,
+		ValueFields:     []string{"millis"},
+		ValueFieldTypes: []string{data.FieldTypeInt64.ItemTypeString()},
+	}
+}
+
+// getProfilerTableQueryModel produces the query used to parse the table of the top shapes
+func (m *QueryModel) getProfilerTableQueryModel() QueryModel {
+	return QueryModel{
+		QueryType: queryTypeTable,
+		ValueFields: []string{
+			"shape", "ns", "op", "count", "totalMillis", "avgMillis", "docsExamined", "keysExamined",
+		},
+		ValueFieldTypes: []string{
+			data.FieldTypeString.ItemTypeString(),
+			data.FieldTypeString.ItemTypeString(),
+			data.FieldTypeString.ItemTypeString(),
+			data.FieldTypeInt64.ItemTypeString(),
+			data.FieldTypeInt64.ItemTypeString(),
+			data.FieldTypeFloat64.ItemTypeString(),
+			data.FieldTypeInt64.ItemTypeString(),
+			data.FieldTypeInt64.ItemTypeString(),
+		},
+	}
+}
+
+// getProfilerTopN returns how many shapes to include in the table
+func (m *QueryModel) getProfilerTopN() int {
+	if m.ProfilerTopN <= 0 {
+		return defaultProfilerTopN
+	}
+	return m.ProfilerTopN
+}
+
+// getProfilerRankBy returns the statistic to rank the shapes in the table by
+func (m *QueryModel) getProfilerRankBy() string {
+	if m.ProfilerRankBy == "" {
+		return defaultProfilerRankBy
+	}
+	return m.ProfilerRankBy
+}
+
+// runProfiler reads the operations recorded by the profiler of the database within the time range,
+// and groups them by shape. It produces a series for each shape with the total time spent on it in
+// automatic time buckets, and a table of the top shapes by a statistic, total time by default.
+func (m *QueryModel) runProfiler(ctx context.Context, database *mongo.Database, r queryRange) backend.DataResponse {
+	response := backend.DataResponse{}
+
+	filter, err := getTimeBoundMatch("ts", "", r.From, r.To)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	opts := mongoOpts.Find().SetSort(bson.D{bson.E{Key: "ts", Value: 1}})
+	log.DefaultLogger.Debug("Reading profiler", "database", database.Name(), "filter", filter)
+	cursor, err := database.Collection(profilerCollection).Find(ctx, filter, opts)
+	if err != nil {
+		response.Error = errors.Wrap(err, "Failed to send query to mongo")
+		return response
+	}
+	defer cursor.Close(ctx)
+
+	interval := getBucketInterval(r)
+	shapes := make(map[string]*profileShape)
+	buckets := make([]bson.D, 0)
+	bucketIndexes := make(map[string]int)
+	var bucketStart time.Time
+	for cursor.Next(ctx) {
+		entry := profileEntry{}
+		err = cursor.Decode(&entry)
+		if err != nil {
+			response.Error = errors.Wrap(err, "Failed to decode profiler entry")
+			return response
+		}
+		shape, err := entry.getShape()
+		if err != nil {
+			response.Error = errors.Wrap(err, "Failed to normalize profiled command")
+			return response
+		}
+
+		totals, ok := shapes[shape]
+		if !ok {
+			totals = &profileShape{shape: shape, ns: entry.Ns, op: entry.Op}
+			shapes[shape] = totals
+		}
+		totals.count++
+		totals.millis += entry.Millis
+		totals.docsExamined += entry.DocsExamined
+		totals.keysExamined += entry.KeysExamined
+
+		// Entries are sorted by time, so once a new bucket starts, no earlier bucket will be seen again
+		if start := getBucketStart(entry.Ts, interval); !start.Equal(bucketStart) {
+			bucketStart = start
+			bucketIndexes = make(map[string]int)
+		}
+		if ix, ok := bucketIndexes[shape]; ok {
+			bucket := buckets[ix]
+			bucket[3].Value = bucket[3].Value.(int64) + entry.Millis
+			continue
+		}
+		bucketIndexes[shape] = len(buckets)
+		buckets = append(buckets, bson.D{
+			bson.E{Key: "ts", Value: bsonPrim.NewDateTimeFromTime(bucketStart)},
+			bson.E{Key: "ns", Value: entry.Ns},
+			bson.E{Key: "shape", Value: shape},
+			bson.E{Key: "millis", Value: entry.Millis},
+		})
+	}
+	if err = cursor.Err(); err != nil {
+		response.Error = errors.Wrap(err, "Failed to read profiler entries")
+		return response
+	}
+
+	timeseries := m.getProfilerTimeseriesQueryModel()
+	response = readCursor(ctx, &timeseries, newSliceSource(buckets))
+	if response.Error != nil {
+		return response
+	}
+
+	table := m.getProfilerTableQueryModel()
+	top, err := getTopProfileShapes(shapes, m.getProfilerTopN(), m.getProfilerRankBy())
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	tableResponse := readCursor(ctx, &table, newSliceSource(top))
+	if tableResponse.Error != nil {
+		return tableResponse
+	}
+	for _, frame := range tableResponse.Frames {
+		frame.Name = "top"
+		frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeTable}
+	}
+	response.Frames = append(response.Frames, tableResponse.Frames...)
+	return response
+}
+
+// profileShapeStatistics are the statistics the table of shapes can be ranked by, by the name of their column
+var profileShapeStatistics = map[string]func(*profileShape) float64{
+	"totalMillis":  func(s *profileShape) float64 { return float64(s.millis) },
+	"avgMillis":    func(s *profileShape) float64 { return float64(s.millis) / float64(s.count) },
+	"count":        func(s *profileShape) float64 { return float64(s.count) },
+	"docsExamined": func(s *profileShape) float64 { return float64(s.docsExamined) },
+	"keysExamined": func(s *profileShape) float64 { return float64(s.keysExamined) },
+}
+
+// getTopProfileShapes produces a document for each of the n shapes with the highest value of a statistic,
+// highest first. Ties are ordered by shape, so that the table is stable between refreshes
+func getTopProfileShapes(shapes map[string]*profileShape, n int, rankBy string) ([]bson.D, error) {
+	statistic, ok := profileShapeStatistics[rankBy]
+	if !ok {
+		return nil, fmt.Errorf("Cannot rank profiled shapes by %s", rankBy)
+	}
+	sorted := make([]*profileShape, 0, len(shapes))
+	for _, shape := range shapes {
+		sorted = append(sorted, shape)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		vi, vj := statistic(sorted[i]), statistic(sorted[j])
+		if vi != vj {
+			return vi > vj
+		}
+		return sorted[i].shape < sorted[j].shape
+	})
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	docs := make([]bson.D, len(sorted))
+	for ix, shape := range sorted {
+		docs[ix] = bson.D{
+			bson.E{Key: "shape", Value: shape.shape},
+			bson.E{Key: "ns", Value: shape.ns},
+			bson.E{Key: "op", Value: shape.op},
+			bson.E{Key: "count", Value: shape.count},
+			bson.E{Key: "totalMillis", Value: shape.millis},
+			bson.E{Key: "avgMillis", Value: float64(shape.millis) / float64(shape.count)},
+			bson.E{Key: "docsExamined", Value: shape.docsExamined},
+			bson.E{Key: "keysExamined", Value: shape.keysExamined},
+		}
+	}
+	return docs, nil
+}
@@ -0,0 +1,92 @@
+package plugin_test
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GetProfileShape", func() {
+	DescribeTable("should normalize",
+		func(command, query bson.D, expected string) {
+			shape, err := plugin.GetProfileShape(command, query)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(shape).To(Equal(expected))
+		},
+		Entry("a command by redacting its literals",
+			bson.D{{Key: "find", Value: "users"}, {Key: "filter", Value: bson.D{{Key: "age", Value: int32(30)}}}},
+			nil,
+			`{"find":"users","filter":{"age":"?"}}`,
+		),
+		Entry("a command by removing its session and connection details",
+			bson.D{
+				{Key: "find", Value: "users"},
+				{Key: "filter", Value: bson.D{{Key: "age", Value: int32(31)}}},
+				{Key: "lsid", Value: bson.D{{Key: "id", Value: "session"}}},
+				{Key: "$db", Value: "app"},
+				{Key: "maxTimeMS", Value: int32(1000)},
+			},
+			nil,
+			`{"find":"users","filter":{"age":"?"}}`,
+		),
+		Entry("the query of an older server without a command",
+			nil,
+			bson.D{{Key: "name", Value: "a"}},
+			`{"name":"?"}`,
+		),
+	)
+})
+
+var _ = Describe("GetTopProfileShapes", func() {
+	shapes := map[string]*plugin.ProfileShape{
+		"a": plugin.NewProfileShape("a", 1, 100, 10, 0),
+		"b": plugin.NewProfileShape("b", 10, 200, 5, 5),
+		"c": plugin.NewProfileShape("c", 4, 200, 0, 20),
+	}
+
+	// shapeNames returns the shape of each row of the table
+	shapeNames := func(docs []bson.D) []string {
+		names := make([]string, len(docs))
+		for ix, doc := range docs {
+			names[ix] = doc[0].Value.(string)
+		}
+		return names
+	}
+
+	DescribeTable("should rank",
+		func(n int, rankBy string, expected []string) {
+			docs, err := plugin.GetTopProfileShapes(shapes, n, rankBy)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(shapeNames(docs)).To(Equal(expected))
+		},
+		Entry("by total time, ordering ties by shape", 10, "totalMillis", []string{"b", "c", "a"}),
+		Entry("only the top n", 2, "totalMillis", []string{"b", "c"}),
+		Entry("by average time", 10, "avgMillis", []string{"a", "c", "b"}),
+		Entry("by count", 10, "count", []string{"b", "c", "a"}),
+		Entry("by documents examined", 10, "docsExamined", []string{"a", "b", "c"}),
+		Entry("by keys examined", 10, "keysExamined", []string{"c", "b", "a"}),
+	)
+
+	It("Should produce the statistics of each shape", func() {
+		docs, err := plugin.GetTopProfileShapes(map[string]*plugin.ProfileShape{"b": shapes["b"]}, 10, "totalMillis")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(docs).To(Equal([]bson.D{{
+			{Key: "shape", Value: "b"},
+			{Key: "ns", Value: ""},
+			{Key: "op", Value: ""},
+			{Key: "count", Value: int64(10)},
+			{Key: "totalMillis", Value: int64(200)},
+			{Key: "avgMillis", Value: float64(20)},
+			{Key: "docsExamined", Value: int64(5)},
+			{Key: "keysExamined", Value: int64(5)},
+		}}))
+	})
+
+	It("Should reject an unknown statistic", func() {
+		_, err := plugin.GetTopProfileShapes(shapes, 10, "bogus")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,137 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// replSetStatus is the subset of the reply to replSetGetStatus used by this plugin
+type replSetStatus struct {
+	Set     string          `bson:"set"`
+	Date    time.Time       `bson:"date"`
+	Members []replSetMember `bson:"members"`
+}
+
+// replSetMember is the status of a single member of a replica set, as reported by replSetGetStatus
+type replSetMember struct {
+	Name       string    `bson:"name"`
+	Health     float64   `bson:"health"`
+	State      int32     `bson:"state"`
+	StateStr   string    `bson:"stateStr"`
+	OptimeDate time.Time `bson:"optimeDate"`
+	PingMs     *int64    `bson:"pingMs"`
+	Self       bool      `bson:"self"`
+}
+
+const (
+	replSetStatePrimary   = 1
+	replSetStateSecondary = 2
+)
+
+// getReplSetStatus runs replSetGetStatus against the admin database
+func getReplSetStatus(ctx context.Context, client *mongo.Client) (*replSetStatus, error) {
+	status := replSetStatus{}
+	err := client.Database("admin").RunCommand(ctx, bson.D{bson.E{Key: "replSetGetStatus", Value: 1}}).Decode(&status)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get replica set status")
+	}
+	return &status, nil
+}
+
+// primary returns the current primary member, if there is one
+func (s *replSetStatus) primary() (replSetMember, bool) {
+	for _, member := range s.Members {
+		if member.State == replSetStatePrimary {
+			return member, true
+		}
+	}
+	return replSetMember{}, false
+}
+
+// lag returns how far a member is behind the primary. Only secondaries have a lag, and only while there
+// is a primary. The optime of the primary can be older than that of a secondary while it is being
+// reported, in which case the secondary is not behind, and its lag is zero.
+func (s *replSetStatus) lag(member replSetMember) (time.Duration, bool) {
+	if member.State != replSetStateSecondary {
+		return 0, false
+	}
+	primary, ok := s.primary()
+	if !ok {
+		return 0, false
+	}
+	lag := primary.OptimeDate.Sub(member.OptimeDate)
+	if lag < 0 {
+		return 0, true
+	}
+	return lag, true
+}
+
+// runReplicaSet runs replSetGetStatus, producing a table of the members of the replica set,
+// and a frame with the lag of each secondary at the time of the status
+func runReplicaSet(ctx context.Context, client *mongo.Client) backend.DataResponse {
+	response := backend.DataResponse{}
+	status, err := getReplSetStatus(ctx, client)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	log.DefaultLogger.Debug("Replica set status", "status", status)
+	response.Frames = data.Frames{status.membersFrame(), status.lagFrame()}
+	return response
+}
+
+// membersFrame produces a table with one row for each member of the replica set.
+// Lag is only present for secondaries, and ping is absent for the member which reported the status.
+func (s *replSetStatus) membersFrame() *data.Frame {
+	frame := data.NewFrame("members",
+		data.NewField("name", nil, []string{}),
+		data.NewField("state", nil, []string{}),
+		data.NewField("health", nil, []float64{}),
+		data.NewField("optime", nil, []time.Time{}),
+		data.NewField("lagSeconds", nil, []*float64{}),
+		data.NewField("pingMs", nil, []*int64{}),
+		data.NewField("self", nil, []bool{}),
+	)
+	frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeTable}
+	for _, member := range s.Members {
+		var lagSeconds *float64
+		if lag, ok := s.lag(member); ok {
+			seconds := lag.Seconds()
+			lagSeconds = &seconds
+		}
+		frame.AppendRow(
+			member.Name,
+			member.StateStr,
+			member.Health,
+			member.OptimeDate,
+			lagSeconds,
+			member.PingMs,
+			member.Self,
+		)
+	}
+	return frame
+}
+
+// lagFrame produces a single row with the lag of each secondary, labeled by member name
+func (s *replSetStatus) lagFrame() *data.Frame {
+	frame := data.NewFrame("lag", data.NewField("time", nil, []time.Time{s.Date}))
+	for _, member := range s.Members {
+		lag, ok := s.lag(member)
+		if !ok {
+			continue
+		}
+		frame.Fields = append(frame.Fields, data.NewField(
+			"lagSeconds",
+			data.Labels{"set": s.Set, "member": member.Name},
+			[]float64{lag.Seconds()},
+		))
+	}
+	return frame
+}
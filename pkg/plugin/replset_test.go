@@ -0,0 +1,159 @@
+package plugin_test
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonprim "go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// int64Pointer returns a pointer to a copy of an int64
+func int64Pointer(v int64) *int64 {
+	return &v
+}
+
+// replSetMember produces the status of a member whose optime is a number of seconds before nowMillis.
+// Arbiters have no optime.
+func replSetMember(name, stateStr string, state int32, behind int) bson.D {
+	member := bson.D{
+		{Key: "name", Value: name},
+		{Key: "health", Value: float64(1)},
+		{Key: "state", Value: state},
+		{Key: "stateStr", Value: stateStr},
+	}
+	if stateStr == "ARBITER" {
+		return member
+	}
+	optime := nowMillis.Add(-time.Duration(behind) * time.Second)
+	return append(member, bson.E{Key: "optimeDate", Value: bsonprim.NewDateTimeFromTime(optime)})
+}
+
+// replSetStatus produces a reply to replSetGetStatus, as reported by its first member,
+// which has no ping
+func replSetStatus(members ...bson.D) bson.D {
+	statuses := make(bson.A, len(members))
+	for ix, member := range members {
+		status := append(bson.D{}, member...)
+		if ix == 0 {
+			status = append(status, bson.E{Key: "self", Value: true})
+		} else {
+			status = append(status, bson.E{Key: "pingMs", Value: int64(1)})
+		}
+		statuses[ix] = status
+	}
+	return bson.D{
+		{Key: "set", Value: "rs0"},
+		{Key: "date", Value: bsonprim.NewDateTimeFromTime(nowMillis)},
+		{Key: "members", Value: statuses},
+		{Key: "ok", Value: float64(1)},
+	}
+}
+
+var (
+	rsPrimary          = replSetMember("a:27017", "PRIMARY", 1, 0)
+	rsStalePrimary     = replSetMember("a:27017", "PRIMARY", 1, 5)
+	rsLaggingSecondary = replSetMember("b:27017", "SECONDARY", 2, 2)
+	rsCurrentSecondary = replSetMember("c:27017", "SECONDARY", 2, 0)
+	rsArbiter          = replSetMember("d:27017", "ARBITER", 7, 0)
+)
+
+var _ = Describe("ReplSetLags", func() {
+	DescribeTable("should compute the lag",
+		func(reply bson.D, expected map[string]time.Duration) {
+			lags, err := plugin.GetReplSetLags(reply)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lags).To(Equal(expected))
+		},
+		Entry("of each secondary behind the primary",
+			replSetStatus(rsPrimary, rsLaggingSecondary, rsCurrentSecondary),
+			map[string]time.Duration{"b:27017": 2 * time.Second, "c:27017": 0},
+		),
+		Entry("of no member without a primary",
+			replSetStatus(rsLaggingSecondary, rsCurrentSecondary),
+			map[string]time.Duration{},
+		),
+		Entry("of no arbiter",
+			replSetStatus(rsPrimary, rsLaggingSecondary, rsArbiter),
+			map[string]time.Duration{"b:27017": 2 * time.Second},
+		),
+		Entry("as zero for a secondary ahead of a stale primary optime",
+			replSetStatus(rsStalePrimary, rsLaggingSecondary),
+			map[string]time.Duration{"b:27017": 0},
+		),
+	)
+})
+
+var _ = Describe("ReplSetFrames", func() {
+	// lagSeconds returns the lag of each row of the members frame, with -1 for an absent lag
+	lagSeconds := func(members *data.Frame) []float64 {
+		field, _ := members.FieldByName("lagSeconds")
+		lags := make([]float64, field.Len())
+		for ix := range lags {
+			lag, ok := field.ConcreteAt(ix)
+			if !ok {
+				lags[ix] = -1
+				continue
+			}
+			lags[ix] = lag.(float64)
+		}
+		return lags
+	}
+	// lagMembers returns the value of each lag field of the lag frame, by its member label
+	lagMembers := func(lag *data.Frame) map[string]float64 {
+		members := map[string]float64{}
+		for _, field := range lag.Fields[1:] {
+			Expect(field.Name).To(Equal("lagSeconds"))
+			Expect(field.Labels).To(HaveKeyWithValue("set", "rs0"))
+			members[field.Labels["member"]] = field.At(0).(float64)
+		}
+		return members
+	}
+
+	DescribeTable("should produce",
+		func(reply bson.D, expectedLagSeconds []float64, expectedLags map[string]float64) {
+			members, lag, err := plugin.GetReplSetFrames(reply)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(members.Rows()).To(Equal(len(expectedLagSeconds)))
+			Expect(lagSeconds(members)).To(Equal(expectedLagSeconds))
+			self, _ := members.FieldByName("self")
+			pingMs, _ := members.FieldByName("pingMs")
+			Expect(self.At(0)).To(BeTrue())
+			Expect(pingMs.At(0)).To(BeNil(), "the member which reported the status has no ping")
+			for ix := 1; ix < members.Rows(); ix++ {
+				Expect(self.At(ix)).To(BeFalse())
+				Expect(pingMs.At(ix)).To(Equal(int64Pointer(1)))
+			}
+
+			Expect(lag.Rows()).To(Equal(1))
+			Expect(lag.Fields[0].At(0)).To(BeTemporally("==", nowMillis))
+			Expect(lagMembers(lag)).To(Equal(expectedLags))
+		},
+		Entry("rows and lags for a healthy replica set",
+			replSetStatus(rsPrimary, rsLaggingSecondary, rsCurrentSecondary),
+			[]float64{-1, 2, 0},
+			map[string]float64{"b:27017": 2, "c:27017": 0},
+		),
+		Entry("rows without lags when there is no primary",
+			replSetStatus(rsLaggingSecondary, rsCurrentSecondary),
+			[]float64{-1, -1},
+			map[string]float64{},
+		),
+		Entry("a row without a lag for an arbiter",
+			replSetStatus(rsPrimary, rsArbiter, rsLaggingSecondary),
+			[]float64{-1, -1, 2},
+			map[string]float64{"b:27017": 2},
+		),
+		Entry("a zero lag for a secondary ahead of a stale primary optime",
+			replSetStatus(rsLaggingSecondary, rsStalePrimary),
+			[]float64{0, -1},
+			map[string]float64{"b:27017": 0},
+		),
+	)
+})
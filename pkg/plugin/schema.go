@@ -11,17 +11,29 @@ import (
 
 type schemaInferenceState struct {
 	typeGuesses map[string]data.FieldType
-	currentRow  map[string]data.FieldType
-	ignored     map[string]struct{}
-	afterFirst  bool
+	// order contains the keys of typeGuesses in the order they were first seen
+	order      []string
+	currentRow map[string]data.FieldType
+	// currentKeys contains the keys of currentRow in document order
+	currentKeys []string
+	// seenTypes contains every distinct (non-nullable) type seen for each field, in the order seen
+	seenTypes map[string][]data.FieldType
+	ignored   map[string]struct{}
+	// fallbackToString allows fields with otherwise incompatible types to be widened to strings or JSON
+	fallbackToString bool
+	afterFirst       bool
 }
 
-func NewSchemaInference(ignored map[string]struct{}) schemaInferenceState {
+func NewSchemaInference(ignored map[string]struct{}, fallbackToString bool) schemaInferenceState {
 	return schemaInferenceState{
-		typeGuesses: make(map[string]data.FieldType),
-		currentRow:  make(map[string]data.FieldType),
-		ignored:     ignored,
-		afterFirst:  false,
+		seenTypes:        make(map[string][]data.FieldType),
+		fallbackToString: fallbackToString,
+		typeGuesses:      make(map[string]data.FieldType),
+		order:            make([]string, 0),
+		currentRow:       make(map[string]data.FieldType),
+		currentKeys:      make([]string, 0),
+		ignored:          ignored,
+		afterFirst:       false,
 	}
 }
 
@@ -38,13 +50,20 @@ func (s *schemaInferenceState) updateField(name string, value interface{}) error
 	if guess == data.FieldTypeUnknown {
 		return nil
 	}
+	if _, seen := s.currentRow[name]; !seen {
+		s.currentKeys = append(s.currentKeys, name)
+	}
+	s.recordType(name, guess)
 	s.currentRow[name] = guess
 	return nil
 }
 
-func (s *schemaInferenceState) updateDoc(doc timestepDocument) error {
-	for name, value := range doc {
-		err := s.updateField(name, value)
+// updateDoc guesses the types of the fields of a document, given its keys in document order.
+// Inferred fields are ordered by the first document in which they appear, and then by their
+// order within that document.
+func (s *schemaInferenceState) updateDoc(doc timestepDocument, keys []string) error {
+	for _, name := range keys {
+		err := s.updateField(name, doc[name])
 		if err != nil {
 			return err
 		}
@@ -65,19 +84,29 @@ func (s *schemaInferenceState) nextDoc() error {
 			}
 		}
 	}
-	for name, currentType := range s.currentRow {
+	for _, name := range s.currentKeys {
+		currentType := s.currentRow[name]
 		guess, known := s.typeGuesses[name]
 		if !s.afterFirst {
 			// If this is the first document, guesses /are/ the current row
 			s.typeGuesses[name] = currentType
+			s.order = append(s.order, name)
 		} else if s.afterFirst && !known && !currentType.Nullable() {
 			// If a new field is found after the first document,
 			// the old guess must be made nullable
 			log.DefaultLogger.Debug("Field missing after first row, making type nullable", "name", name, "row", s.typeGuesses, "guesses", s.typeGuesses)
 			s.typeGuesses[name] = currentType.NullableType()
+			s.order = append(s.order, name)
 		} else if s.afterFirst && known && (currentType == guess || currentType.NullableType() == guess) {
 			// If the new guess and the old guess are the same modulo nullability,
 			// then no action is necessary
+		} else if widened, ok := widenType(guess.NonNullableType(), currentType, s.fallbackToString); ok {
+			// If the types are different, but can both be represented by a wider type, use that instead
+			log.DefaultLogger.Debug("Field appeared with a different type, widening", "name", name, "old", guess, "new", currentType, "widened", widened)
+			if guess.Nullable() {
+				widened = widened.NullableType()
+			}
+			s.typeGuesses[name] = widened
 		} else {
 			// Otherwise, record a mismatch
 			// We don't exit immediately so that the user can know about and fix all mismatches at once
@@ -90,7 +119,11 @@ func (s *schemaInferenceState) nextDoc() error {
 		errMsg := strings.Builder{}
 		errMsg.WriteString("Field(s) appeared with different types. Please ensure value fields are the same type in each document: ")
 		first := false
-		for name, old := range mismatchOld {
+		for _, name := range s.currentKeys {
+			old, mismatched := mismatchOld[name]
+			if !mismatched {
+				continue
+			}
 			new := mismatchNew[name]
 			if first {
 				errMsg.WriteString(",")
@@ -104,13 +137,48 @@ func (s *schemaInferenceState) nextDoc() error {
 
 	s.afterFirst = true
 	s.currentRow = make(map[string]data.FieldType, len(s.typeGuesses))
+	s.currentKeys = s.currentKeys[:0]
 	return nil
 }
 
+func (s *schemaInferenceState) recordType(name string, type_ data.FieldType) {
+	for _, seen := range s.seenTypes[name] {
+		if seen == type_ {
+			return
+		}
+	}
+	s.seenTypes[name] = append(s.seenTypes[name], type_)
+}
+
+// notices describes each field which was widened because it appeared with more than one type
+func (s *schemaInferenceState) notices() []data.Notice {
+	notices := make([]data.Notice, 0)
+	for _, name := range s.order {
+		seen := s.seenTypes[name]
+		if len(seen) < 2 {
+			continue
+		}
+		seenNames := make([]string, len(seen))
+		for ix, type_ := range seen {
+			seenNames[ix] = type_.ItemTypeString()
+		}
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text: fmt.Sprintf(
+				"Field %s appeared with types %s, and was widened to %s",
+				name,
+				strings.Join(seenNames, ", "),
+				s.typeGuesses[name].NonNullableType().ItemTypeString(),
+			),
+		})
+	}
+	return notices
+}
+
 func (s *schemaInferenceState) finish() []field {
 	fields := make([]field, 0, len(s.typeGuesses))
-	for name, guess := range s.typeGuesses {
-		fields = append(fields, field{Name: name, Type: guess})
+	for _, name := range s.order {
+		fields = append(fields, field{Name: name, Type: s.typeGuesses[name]})
 	}
 	return fields
 }
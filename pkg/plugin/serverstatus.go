@@ -0,0 +1,213 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// serverStatusTimeField is the name of the field containing the time a serverStatus sample was taken
+const serverStatusTimeField = "time"
+
+// serverStatusMetric is a numeric field of the reply to serverStatus
+type serverStatusMetric struct {
+	// path is the path of the metric in the reply. The key names of some metrics contain spaces,
+	// but none contain dots.
+	path string
+	// counter is true if the metric is cumulative since the server started
+	counter bool
+}
+
+// serverStatusMetrics are the metrics sampled by a ServerStatus query
+var serverStatusMetrics = []serverStatusMetric{
+	{path: "uptime"},
+	{path: "connections.current"},
+	{path: "connections.available"},
+	{path: "connections.totalCreated", counter: true},
+	{path: "opcounters.insert", counter: true},
+	{path: "opcounters.query", counter: true},
+	{path: "opcounters.update", counter: true},
+	{path: "opcounters.delete", counter: true},
+	{path: "opcounters.getmore", counter: true},
+	{path: "opcounters.command", counter: true},
+	{path: "opcountersRepl.insert", counter: true},
+	{path: "opcountersRepl.update", counter: true},
+	{path: "opcountersRepl.delete", counter: true},
+	{path: "network.bytesIn", counter: true},
+	{path: "network.bytesOut", counter: true},
+	{path: "network.numRequests", counter: true},
+	{path: "mem.resident"},
+	{path: "mem.virtual"},
+	{path: "globalLock.currentQueue.readers"},
+	{path: "globalLock.currentQueue.writers"},
+	{path: "globalLock.activeClients.readers"},
+	{path: "globalLock.activeClients.writers"},
+	{path: "asserts.regular", counter: true},
+	{path: "asserts.warning", counter: true},
+	{path: "asserts.user", counter: true},
+	{path: "metrics.document.returned", counter: true},
+	{path: "metrics.document.inserted", counter: true},
+	{path: "metrics.document.updated", counter: true},
+	{path: "metrics.document.deleted", counter: true},
+	{path: "metrics.queryExecutor.scanned", counter: true},
+	{path: "metrics.queryExecutor.scannedObjects", counter: true},
+	{path: "wiredTiger.cache.bytes currently in the cache"},
+	{path: "wiredTiger.cache.maximum bytes configured"},
+	{path: "wiredTiger.cache.tracked dirty bytes in the cache"},
+	{path: "wiredTiger.cache.pages read into cache", counter: true},
+	{path: "wiredTiger.cache.pages written from cache", counter: true},
+}
+
+// replicationMetrics are the metrics derived from replSetGetStatus, if requested
+var replicationMetrics = []serverStatusMetric{
+	{path: "replication.members"},
+	{path: "replication.healthyMembers"},
+	{path: "replication.maxLagSeconds"},
+}
+
+// getServerStatusMetrics returns the metrics sampled by a ServerStatus query
+func (m *QueryModel) getServerStatusMetrics() []serverStatusMetric {
+	if !m.ServerStatusReplication {
+		return serverStatusMetrics
+	}
+	metrics := make([]serverStatusMetric, 0, len(serverStatusMetrics)+len(replicationMetrics))
+	metrics = append(metrics, serverStatusMetrics...)
+	return append(metrics, replicationMetrics...)
+}
+
+// getServerStatusFields returns the fixed schema of a ServerStatus query.
+// Metrics are absent if the server does not report them, e.g. WiredTiger metrics with another storage engine
+func (m *QueryModel) getServerStatusFields() []field {
+	metrics := m.getServerStatusMetrics()
+	fields := make([]field, len(metrics))
+	for ix, metric := range metrics {
+		fields[ix] = field{Name: metric.path, Type: data.FieldTypeNullableFloat64}
+	}
+	return fields
+}
+
+// runServerStatus samples serverStatus, and optionally replSetGetStatus, producing a single document
+// with the time of the sample and the value of each metric
+func (m *QueryModel) runServerStatus(ctx context.Context, client *mongo.Client) (documentSource, error) {
+	reply := bson.D{}
+	err := client.Database("admin").RunCommand(ctx, bson.D{bson.E{Key: "serverStatus", Value: 1}}).Decode(&reply)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get server status")
+	}
+	status, _ := flattenDocument(reply, maxFlattenDepth)
+
+	sample := make(bson.D, 0, 1+len(serverStatusMetrics)+len(replicationMetrics))
+	sampleTime, ok := status["localTime"]
+	if !ok {
+		return nil, errors.New("Server status did not include the local time")
+	}
+	sample = append(sample, bson.E{Key: serverStatusTimeField, Value: sampleTime})
+	for _, metric := range serverStatusMetrics {
+		value, ok := status[metric.path]
+		if !ok {
+			continue
+		}
+		sample = append(sample, bson.E{Key: metric.path, Value: value})
+	}
+
+	if m.ServerStatusReplication {
+		replSet, err := getReplSetStatus(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		sample = append(sample, replSet.getMetrics()...)
+	}
+
+	log.DefaultLogger.Debug("Sampled server status", "sample", sample)
+	return newSliceSource([]bson.D{sample}), nil
+}
+
+// getMetrics summarizes the health of a replica set as the elements of a serverStatus sample
+func (s *replSetStatus) getMetrics() bson.D {
+	healthy := 0
+	var maxLag time.Duration
+	hasLag := false
+	for _, member := range s.Members {
+		if member.Health == 1 {
+			healthy++
+		}
+		if lag, ok := s.lag(member); ok && (!hasLag || lag > maxLag) {
+			maxLag = lag
+			hasLag = true
+		}
+	}
+	metrics := bson.D{
+		bson.E{Key: "replication.members", Value: int32(len(s.Members))},
+		bson.E{Key: "replication.healthyMembers", Value: int32(healthy)},
+	}
+	if hasLag {
+		metrics = append(metrics, bson.E{Key: "replication.maxLagSeconds", Value: maxLag.Seconds()})
+	}
+	return metrics
+}
+
+// serverStatusRates converts the cumulative counters of consecutive serverStatus samples to per-second rates
+type serverStatusRates struct {
+	counters map[string]struct{}
+	previous map[string]counterSample
+}
+
+// counterSample is the value of a counter at the time of the sample it was last present in
+type counterSample struct {
+	time  time.Time
+	value float64
+}
+
+func newServerStatusRates(metrics []serverStatusMetric) *serverStatusRates {
+	counters := make(map[string]struct{})
+	for _, metric := range metrics {
+		if metric.counter {
+			counters[metric.path] = struct{}{}
+		}
+	}
+	return &serverStatusRates{
+		counters: counters,
+		previous: make(map[string]counterSample),
+	}
+}
+
+// apply replaces the value of each counter in a frame of samples with its rate since the previous sample
+// it was present in. The rate is absent for the first sample, and if the counter was reset, e.g. by a restart.
+func (r *serverStatusRates) apply(frame *data.Frame) error {
+	timeField, _ := frame.FieldByName(serverStatusTimeField)
+	if timeField == nil {
+		return errors.New("Server status sample has no time")
+	}
+	for row := 0; row < frame.Rows(); row++ {
+		sampleTime, ok := timeField.At(row).(time.Time)
+		if !ok {
+			return errors.New("Server status sample time must be a time")
+		}
+		for _, field := range frame.Fields {
+			if _, isCounter := r.counters[field.Name]; !isCounter {
+				continue
+			}
+			value, ok := field.ConcreteAt(row)
+			if !ok {
+				field.Set(row, (*float64)(nil))
+				continue
+			}
+			current := value.(float64)
+			previous, hasPrevious := r.previous[field.Name]
+			r.previous[field.Name] = counterSample{time: sampleTime, value: current}
+			elapsed := sampleTime.Sub(previous.time).Seconds()
+			if !hasPrevious || elapsed <= 0 || current < previous.value {
+				field.Set(row, (*float64)(nil))
+				continue
+			}
+			rate := (current - previous.value) / elapsed
+			field.Set(row, &rate)
+		}
+	}
+	return nil
+}
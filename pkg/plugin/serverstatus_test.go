@@ -0,0 +1,84 @@
+package plugin_test
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// float64Pointer returns a pointer to a copy of a float64
+func float64Pointer(v float64) *float64 {
+	return &v
+}
+
+// serverStatusFrame produces a frame of serverStatus samples taken a number of seconds after now
+func serverStatusFrame(seconds []int, inserts []*float64, uptimes []*float64) *data.Frame {
+	times := make([]time.Time, len(seconds))
+	for ix, s := range seconds {
+		times[ix] = now.Add(time.Duration(s) * time.Second)
+	}
+	return data.NewFrame("",
+		data.NewField("time", nil, times),
+		data.NewField("opcounters.insert", nil, inserts),
+		data.NewField("uptime", nil, uptimes),
+	)
+}
+
+var _ = Describe("ServerStatusRates", func() {
+	DescribeTable("should convert counters to rates",
+		func(seconds []int, inserts []*float64, expected []*float64) {
+			uptimes := make([]*float64, len(seconds))
+			for ix, s := range seconds {
+				uptimes[ix] = float64Pointer(float64(s))
+			}
+			frame := serverStatusFrame(seconds, inserts, uptimes)
+			Expect(plugin.ApplyServerStatusRates(frame)).To(Succeed())
+			for ix := range seconds {
+				if expected[ix] == nil {
+					Expect(frame.Fields[1].At(ix)).To(BeNil())
+				} else {
+					Expect(frame.Fields[1].At(ix)).To(Equal(expected[ix]))
+				}
+				Expect(frame.Fields[2].At(ix)).To(Equal(uptimes[ix]), "gauges must be unchanged")
+			}
+		},
+		Entry("per second since the previous sample",
+			[]int{0, 10, 20},
+			[]*float64{float64Pointer(100), float64Pointer(200), float64Pointer(250)},
+			[]*float64{nil, float64Pointer(10), float64Pointer(5)},
+		),
+		Entry("as absent after a counter reset",
+			[]int{0, 10, 20},
+			[]*float64{float64Pointer(100), float64Pointer(50), float64Pointer(150)},
+			[]*float64{nil, nil, float64Pointer(10)},
+		),
+		Entry("as absent when the counter is absent",
+			[]int{0, 10, 20},
+			[]*float64{float64Pointer(100), nil, float64Pointer(300)},
+			[]*float64{nil, nil, float64Pointer(10)},
+		),
+		Entry("as absent when no time has elapsed",
+			[]int{0, 0},
+			[]*float64{float64Pointer(100), float64Pointer(200)},
+			[]*float64{nil, nil},
+		),
+	)
+
+	It("Should continue from the last sample of the previous frame", func() {
+		first := serverStatusFrame([]int{0}, []*float64{float64Pointer(100)}, []*float64{nil})
+		second := serverStatusFrame([]int{4}, []*float64{float64Pointer(120)}, []*float64{nil})
+		Expect(plugin.ApplyServerStatusRates(first, second)).To(Succeed())
+		Expect(first.Fields[1].At(0)).To(BeNil())
+		Expect(second.Fields[1].At(0)).To(Equal(float64Pointer(5)))
+	})
+
+	It("Should reject samples without a time", func() {
+		frame := data.NewFrame("", data.NewField("opcounters.insert", nil, []*float64{float64Pointer(1)}))
+		Expect(plugin.ApplyServerStatusRates(frame)).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,153 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf16"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+)
+
+type streamMode = string
+
+// Ways in which a query can be streamed over Grafana Live. An empty stream mode means the query is not streamed.
+const (
+	streamModeChangeStream = "ChangeStream"
+	streamModePoll         = "Poll"
+	streamModeTail         = "Tail"
+)
+
+// streamRetryInterval is how long to wait before reopening a stream which failed
+const streamRetryInterval = 5 * time.Second
+
+// hashStreamQuery produces the identifier of a query used in its stream path, in the same way as hashQuery
+// in the frontend. The query JSON is hashed as the UTF-16 code units of its compact form, which is how
+// JSON.stringify produces it
+func hashStreamQuery(queryJSON json.RawMessage) (string, error) {
+	compact := bytes.Buffer{}
+	err := json.Compact(&compact, queryJSON)
+	if err != nil {
+		return "", errors.Wrap(err, "Invalid query JSON")
+	}
+	var h1 uint32 = 0xdeadbeef
+	var h2 uint32 = 0x41c6ce57
+	for _, ch := range utf16.Encode([]rune(compact.String())) {
+		h1 = (h1 ^ uint32(ch)) * 2654435761
+		h2 = (h2 ^ uint32(ch)) * 1597334677
+	}
+	h1 = (h1 ^ (h1 >> 16)) * 2246822507
+	h1 ^= (h2 ^ (h2 >> 13)) * 3266489909
+	h2 = (h2 ^ (h2 >> 16)) * 2246822507
+	h2 ^= (h1 ^ (h1 >> 13)) * 3266489909
+	return fmt.Sprintf("%08x%08x", h2, h1), nil
+}
+
+// parseStreamQuery parses the query a stream was subscribed with, and checks that the path is the one
+// produced by the frontend for it, so that a subscriber cannot join a stream with a different query
+func parseStreamQuery(path string, queryJSON json.RawMessage) (*QueryModel, error) {
+	hash, err := hashStreamQuery(queryJSON)
+	if err != nil {
+		return nil, err
+	}
+	var qm QueryModel
+	err = json.Unmarshal(queryJSON, &qm)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid query JSON")
+	}
+	switch qm.StreamMode {
+	case streamModeChangeStream, streamModeTail:
+	case streamModePoll:
+		err = qm.enablePollingTimeBound()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Stream mode for %s must be one of: %s, %s, %s", path, streamModeChangeStream, streamModePoll, streamModeTail)
+	}
+	if expected := qm.StreamMode + "/" + hash; path != expected {
+		return nil, fmt.Errorf("Stream path %s does not match its query, expected %s", path, expected)
+	}
+	return &qm, nil
+}
+
+// SubscribeStream is called when a panel subscribes to a stream. Each stream path is a hash of its query,
+// so any subscriber with a valid query and a matching path is allowed
+func (d *MongoDBDatasource) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	log.DefaultLogger.Info("SubscribeStream called", "request", req)
+
+	_, err := parseStreamQuery(req.Path, req.Data)
+	if err != nil {
+		log.DefaultLogger.Warn("Rejecting stream subscription", "path", req.Path, "error", err)
+		return &backend.SubscribeStreamResponse{
+			Status: backend.SubscribeStreamStatusNotFound,
+		}, nil
+	}
+	return &backend.SubscribeStreamResponse{
+		Status: backend.SubscribeStreamStatusOK,
+	}, nil
+}
+
+// PublishStream is called when a client tries to publish to a stream. Streams are read-only
+func (d *MongoDBDatasource) PublishStream(ctx context.Context, req *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	log.DefaultLogger.Info("PublishStream called", "request", req)
+
+	return &backend.PublishStreamResponse{
+		Status: backend.PublishStreamStatusPermissionDenied,
+	}, nil
+}
+
+// RunStream is called once for the first subscriber to a stream, and sends frames until there are no subscribers left.
+// Failures are retried until the stream is no longer needed
+func (d *MongoDBDatasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	log.DefaultLogger.Info("RunStream called", "request", req)
+
+	qm, err := parseStreamQuery(req.Path, req.Data)
+	if err != nil {
+		return err
+	}
+	defer d.resumeTokens.Delete(req.Path)
+	defer d.lastSentTimestamps.Delete(req.Path)
+	defer d.tailPositions.Delete(req.Path)
+
+	for {
+		switch qm.StreamMode {
+		case streamModeChangeStream:
+			err = d.runChangeStream(ctx, req.Path, qm, sender)
+		case streamModePoll:
+			err = d.runPollingStream(ctx, req.Path, qm, sender)
+		case streamModeTail:
+			err = d.runTailStream(ctx, req.Path, qm, sender)
+		}
+		if ctx.Err() != nil {
+			log.DefaultLogger.Info("Stream finished", "path", req.Path)
+			return nil
+		}
+		log.DefaultLogger.Warn("Stream failed, retrying", "path", req.Path, "error", err, "retryInterval", streamRetryInterval)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(streamRetryInterval):
+		}
+	}
+}
+
+// sendDocuments converts a batch of documents to frames in the same way as a query, and sends them to the stream
+func sendDocuments(ctx context.Context, qm *QueryModel, source documentSource, sender *backend.StreamSender) error {
+	response := readCursor(ctx, qm, source)
+	if response.Error != nil {
+		return response.Error
+	}
+	for _, frame := range response.Frames {
+		err := sender.SendFrame(frame, data.IncludeAll)
+		if err != nil {
+			return errors.Wrap(err, "Failed to send frame")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// defaultTailFlushInterval is the longest a tailed document is buffered before being sent, if not specified
+	defaultTailFlushInterval = time.Second
+	// defaultTailBufferSize is the most tailed documents which are buffered before being sent, if not specified
+	defaultTailBufferSize = 1000
+)
+
+// getTailFlushInterval returns the longest a tailed document is buffered before being sent
+func (m *QueryModel) getTailFlushInterval() time.Duration {
+	if m.TailFlushIntervalMs <= 0 {
+		return defaultTailFlushInterval
+	}
+	return time.Duration(m.TailFlushIntervalMs) * time.Millisecond
+}
+
+// getTailBufferSize returns the most tailed documents which are buffered before being sent
+func (m *QueryModel) getTailBufferSize() int {
+	if m.TailBufferSize <= 0 {
+		return defaultTailBufferSize
+	}
+	return m.TailBufferSize
+}
+
+// tailPosition is how far a tail stream has read, which is kept while the stream is retried
+type tailPosition struct {
+	// started is true once the documents in the collection when the stream started have been skipped
+	started bool
+	// lastID is the _id of the last document sent or skipped, if any
+	lastID bson.RawValue
+}
+
+// tailSkipper skips the documents returned by a newly opened tailable cursor which were already in the
+// collection when the stream started, or which were already read before the cursor was reopened.
+// A tailable cursor returns the documents of a capped collection in natural order, which is the order they
+// were inserted in, so no assumption is made about the order of their _ids.
+type tailSkipper struct {
+	position tailPosition
+	// resumeAfter is the _id of the last document read before the cursor was reopened, if any
+	resumeAfter bson.RawValue
+	skipping    bool
+}
+
+// newTailSkipper starts skipping for a cursor opened at a position. A new stream skips every document it is
+// opened with, and a resumed stream skips every document up to and including the last one read. A resumed
+// stream which had not read any document skips none, as the collection had no matching documents.
+func newTailSkipper(position tailPosition) *tailSkipper {
+	skipper := &tailSkipper{position: position, skipping: !position.started}
+	if position.started && position.lastID.Type != 0 {
+		skipper.resumeAfter = position.lastID
+		skipper.skipping = true
+	}
+	return skipper
+}
+
+// read records that a document was read, and returns true if it should be skipped
+func (s *tailSkipper) read(id bson.RawValue) bool {
+	skip := s.skipping
+	if skip && s.resumeAfter.Type != 0 && s.resumeAfter.Equal(id) {
+		s.skipping = false
+	}
+	s.position.lastID = id
+	return skip
+}
+
+// caughtUp stops skipping once no more documents are immediately available. It returns true if a resumed
+// stream did not find the last document it read, as it was overwritten by newer documents, in which case
+// the documents inserted after it, but before the cursor was reopened, were skipped as well
+func (s *tailSkipper) caughtUp() bool {
+	missed := s.skipping && s.resumeAfter.Type != 0
+	s.skipping = false
+	s.position.started = true
+	return missed
+}
+
+// runTailStream follows a capped collection with a tailable cursor, and sends each new document matching
+// the filter. Documents are buffered, and sent together once the buffer is full, or the first document in
+// it has waited for the flush interval. A single cursor is kept open, which waits for new documents for up to
+// the flush interval at a time. The documents it returns when it is opened are skipped, see tailSkipper, and
+// the position of the last document sent is kept while the stream is retried, so that documents inserted
+// before it is reopened are not lost.
+// A tailable cursor is only closed by the server when it is invalidated, or if it matches no documents, such
+// as in an empty collection, so it is then reopened after the flush interval.
+func (d *MongoDBDatasource) runTailStream(ctx context.Context, path string, qm *QueryModel, sender *backend.StreamSender) error {
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+	collection := client.Database(qm.Database).Collection(qm.Collection)
+
+	filter, err := qm.parseExtJSONDocument("filter", qm.Filter, queryRange{})
+	if err != nil {
+		return err
+	}
+
+	stored, _ := d.tailPositions.Load(path)
+	position, _ := stored.(tailPosition)
+
+	flushInterval := qm.getTailFlushInterval()
+	bufferSize := qm.getTailBufferSize()
+
+	opts := mongoOpts.Find().
+		SetCursorType(mongoOpts.TailableAwait).
+		SetMaxAwaitTime(flushInterval)
+
+	docs := make([]bson.D, 0, bufferSize)
+	var bufferStart time.Time
+	var skipper *tailSkipper
+	flush := func() error {
+		if len(docs) == 0 {
+			return nil
+		}
+		err := sendDocuments(ctx, qm, newSliceSource(docs), sender)
+		if err != nil {
+			return err
+		}
+		position = skipper.position
+		d.tailPositions.Store(path, position)
+		docs = make([]bson.D, 0, bufferSize)
+		return nil
+	}
+
+	for ctx.Err() == nil {
+		skipper = newTailSkipper(position)
+		log.DefaultLogger.Debug("Opening tailable cursor", "database", qm.Database, "collection", qm.Collection, "filter", filter, "position", position)
+		cursor, err := collection.Find(ctx, filter, opts)
+		if err != nil {
+			return errors.Wrap(err, "Failed to open tailable cursor")
+		}
+
+		for ctx.Err() == nil {
+			if cursor.TryNext(ctx) {
+				id := cursor.Current.Lookup("_id")
+				if skipper.read(bson.RawValue{Type: id.Type, Value: append([]byte(nil), id.Value...)}) {
+					continue
+				}
+				doc := bson.D{}
+				err = cursor.Decode(&doc)
+				if err != nil {
+					cursor.Close(context.Background())
+					return errors.Wrap(err, "Failed to decode tailed document")
+				}
+				if len(docs) == 0 {
+					bufferStart = time.Now()
+				}
+				docs = append(docs, doc)
+			} else if err = cursor.Err(); err != nil {
+				cursor.Close(context.Background())
+				return errors.Wrap(err, "Failed to read from tailable cursor")
+			} else {
+				if skipper.skipping {
+					if skipper.caughtUp() {
+						log.DefaultLogger.Warn("Last tailed document was overwritten, documents inserted since may have been skipped", "path", path)
+					}
+					position = skipper.position
+					d.tailPositions.Store(path, position)
+				}
+				if cursor.ID() == 0 {
+					break
+				}
+			}
+
+			if len(docs) >= bufferSize || (len(docs) != 0 && time.Since(bufferStart) >= flushInterval) {
+				err = flush()
+				if err != nil {
+					cursor.Close(context.Background())
+					return err
+				}
+			}
+		}
+		cursor.Close(context.Background())
+
+		err = flush()
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(flushInterval):
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package plugin_test
+
+import (
+	"time"
+
+	bsonprim "go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SkipTailed", func() {
+	// The _ids are deliberately not in insertion order, as only the natural order of the cursor is relied on
+	ids := []interface{}{
+		bsonprim.NewObjectIDFromTimestamp(now.Add(4 * time.Second)),
+		bsonprim.NewObjectIDFromTimestamp(now.Add(2 * time.Second)),
+		"three",
+		int32(4),
+	}
+
+	DescribeTable("should skip",
+		func(started bool, lastID interface{}, caughtUpAfter int, expected []bool, expectedMissed bool) {
+			skipped, missed, err := plugin.SkipTailed(started, lastID, ids, caughtUpAfter)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(skipped).To(Equal(expected))
+			Expect(missed).To(Equal(expectedMissed))
+		},
+		Entry("the documents in the collection when a new stream starts",
+			false, nil, 2, []bool{true, true, false, false}, false,
+		),
+		Entry("nothing when a new stream starts with an empty collection",
+			false, nil, 0, []bool{false, false, false, false}, false,
+		),
+		Entry("the documents up to and including the last one read when a stream is resumed",
+			true, ids[1], 4, []bool{true, true, false, false}, false,
+		),
+		Entry("nothing when a stream is resumed before reading any document",
+			true, nil, 0, []bool{false, false, false, false}, false,
+		),
+		Entry("the documents available when a stream is resumed after the last one read was overwritten",
+			true, "overwritten", 2, []bool{true, true, false, false}, true,
+		),
+	)
+})
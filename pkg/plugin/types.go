@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
 	"time"
@@ -95,23 +96,152 @@ func ToGrafanaValue(value interface{}) (interface{}, data.FieldType, error) {
 	return nil, data.FieldTypeUnknown, fmt.Errorf("Got value with a type not expected to be generated by BSON: %#v (%s)", value, reflect.ValueOf(value).Type())
 }
 
-func convertValue(value interface{}, nullable bool) (interface{}, data.FieldType, error) {
-	converted, type_, err := ToGrafanaValue(value)
+// numericWidening ranks the numeric types which can be losslessly (or nearly so) converted to
+// any numeric type of a higher rank
+var numericWidening = map[data.FieldType]int{
+	data.FieldTypeInt32:   0,
+	data.FieldTypeInt64:   1,
+	data.FieldTypeFloat64: 2,
+}
+
+// widenType finds the narrowest type which can represent values of two non-nullable types.
+// Numeric types widen along int32 -> int64 -> float64. If fallbackToString is set, any other pair of
+// types is widened to JSON if either is already JSON, or to a string otherwise.
+func widenType(a, b data.FieldType, fallbackToString bool) (data.FieldType, bool) {
+	if a == b {
+		return a, true
+	}
+	aRank, aNumeric := numericWidening[a]
+	bRank, bNumeric := numericWidening[b]
+	if aNumeric && bNumeric {
+		if aRank > bRank {
+			return a, true
+		}
+		return b, true
+	}
+	if !fallbackToString {
+		return data.FieldTypeUnknown, false
+	}
+	if a == data.FieldTypeJSON || b == data.FieldTypeJSON {
+		return data.FieldTypeJSON, true
+	}
+	return data.FieldTypeString, true
+}
+
+// widenValue converts a value produced by ToGrafanaValue to a wider type, as chosen by widenType
+func widenValue(value interface{}, from, to data.FieldType) (interface{}, error) {
+	if from == to {
+		return value, nil
+	}
+	switch to {
+	case data.FieldTypeInt64:
+		switch v := value.(type) {
+		case int32:
+			return int64(v), nil
+		}
+	case data.FieldTypeFloat64:
+		switch v := value.(type) {
+		case int32:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		}
+	case data.FieldTypeString:
+		switch v := value.(type) {
+		case json.RawMessage:
+			return string(v), nil
+		case time.Time:
+			return v.Format(time.RFC3339Nano), nil
+		default:
+			return fmt.Sprintf("%v", v), nil
+		}
+	case data.FieldTypeJSON:
+		bytes, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return json.RawMessage(bytes), nil
+	}
+	return nil, fmt.Errorf("Cannot widen %s to %s", from, to)
+}
+
+// narrowValue converts a numeric value produced by ToGrafanaValue to a narrower numeric type,
+// if it can be represented exactly by that type. A float64 is only narrowed if it is a whole
+// number in the range of the integer type, so no value is ever rounded or truncated
+func narrowValue(value interface{}, to data.FieldType) (interface{}, bool) {
+	var f float64
+	switch v := value.(type) {
+	case int64:
+		f = float64(v)
+		if to == data.FieldTypeInt32 && v >= math.MinInt32 && v <= math.MaxInt32 {
+			return int32(v), true
+		}
+		return nil, false
+	case float64:
+		f = v
+	default:
+		return nil, false
+	}
+	if f != math.Trunc(f) {
+		return nil, false
+	}
+	switch to {
+	case data.FieldTypeInt32:
+		if f >= math.MinInt32 && f <= math.MaxInt32 {
+			return int32(f), true
+		}
+	case data.FieldTypeInt64:
+		// float64(math.MaxInt64) rounds up, so it is out of range
+		if f >= math.MinInt64 && f < math.MaxInt64 {
+			return int64(f), true
+		}
+	}
+	return nil, false
+}
+
+// convertValue converts a BSON value to the Grafana representation of the expected type.
+// Numeric values are widened, or narrowed if they can be represented exactly, e.g. a double
+// holding a whole number for an int64 field. If fallbackToString is set, any other value is
+// converted to a string or JSON field, see widenType.
+func convertValue(value interface{}, type_ data.FieldType, fallbackToString bool) (interface{}, error) {
+	converted, actualType, err := ToGrafanaValue(value)
 	if err != nil {
-		return nil, type_, err
+		return nil, err
 	}
 	if converted == nil {
-		return nil, type_, nil
+		return nil, nil
+	}
+	expectedType := type_.NonNullableType()
+	if actualType != expectedType {
+		// Any value can be represented as JSON
+		widened, ok := widenType(actualType, expectedType, fallbackToString || expectedType == data.FieldTypeJSON)
+		// Values widened to JSON can still be converted to strings
+		stringFallback := fallbackToString && expectedType == data.FieldTypeString
+		if ok && (widened == expectedType || stringFallback) {
+			converted, err = widenValue(converted, actualType, expectedType)
+			if err != nil {
+				return nil, err
+			}
+		} else if narrowed, ok := narrowValue(converted, expectedType); ok {
+			converted = narrowed
+		} else {
+			return nil, fmt.Errorf("Type mismatch: expected %s, got %s (%#v, %#v)", type_, actualType, value, converted)
+		}
 	}
-	if !nullable {
-		return converted, type_, nil
+	if !type_.Nullable() {
+		return converted, nil
 	}
 
+	return toPointer(converted), nil
+}
+
+// toPointer returns a pointer to a copy of a value
+func toPointer(value interface{}) interface{} {
 	// Adding e.g. a float64 to a frame of *float64 is not handled seamlessly,
 	// we have do it manually
 	// We can't just do valueValueValue.Addr().Interface(), as scalar's aren't addressable
-	convertedValue := reflect.ValueOf(converted)
+	convertedValue := reflect.ValueOf(value)
 	convertedPtr := reflect.New(convertedValue.Type())
 	convertedPtr.Elem().Set(convertedValue)
-	return convertedPtr.Interface(), type_.NullableType(), nil
+	return convertedPtr.Interface()
 }
@@ -0,0 +1,76 @@
+package plugin_test
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func stringPointer(s string) *string {
+	return &s
+}
+
+var _ = Describe("WidenType", func() {
+	DescribeTable("should widen",
+		func(a, b data.FieldType, fallbackToString bool, expected data.FieldType, valid bool) {
+			widened, ok := plugin.WidenType(a, b, fallbackToString)
+			Expect(ok).To(Equal(valid))
+			if valid {
+				Expect(widened).To(Equal(expected))
+			}
+		},
+		Entry("identical types to themselves", data.FieldTypeBool, data.FieldTypeBool, false, data.FieldTypeBool, true),
+		Entry("int32 and int64 to int64", data.FieldTypeInt32, data.FieldTypeInt64, false, data.FieldTypeInt64, true),
+		Entry("float64 and int32 to float64", data.FieldTypeFloat64, data.FieldTypeInt32, false, data.FieldTypeFloat64, true),
+		Entry("int64 and float64 to float64", data.FieldTypeInt64, data.FieldTypeFloat64, false, data.FieldTypeFloat64, true),
+		Entry("bool and string to nothing without fallback", data.FieldTypeBool, data.FieldTypeString, false, data.FieldTypeUnknown, false),
+		Entry("bool and string to string with fallback", data.FieldTypeBool, data.FieldTypeString, true, data.FieldTypeString, true),
+		Entry("time and int32 to string with fallback", data.FieldTypeTime, data.FieldTypeInt32, true, data.FieldTypeString, true),
+		Entry("JSON and float64 to JSON with fallback", data.FieldTypeJSON, data.FieldTypeFloat64, true, data.FieldTypeJSON, true),
+	)
+})
+
+var _ = Describe("ConvertValue", func() {
+	DescribeTable("should convert",
+		func(value interface{}, type_ data.FieldType, fallbackToString bool, expected interface{}, valid bool) {
+			converted, err := plugin.ConvertValue(value, type_, fallbackToString)
+			if !valid {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			if expected == nil {
+				Expect(converted).To(BeNil())
+				return
+			}
+			Expect(converted).To(Equal(expected))
+		},
+		Entry("an int32 to an int64", int32(1), data.FieldTypeInt64, false, int64(1), true),
+		Entry("an int32 to a nullable float64", int32(1), data.FieldTypeNullableFloat64, false, func() *float64 { f := 1.0; return &f }(), true),
+		Entry("a small int64 to an int32", int64(5), data.FieldTypeInt32, false, int32(5), true),
+		Entry("a large int64 to nothing for an int32", int64(1)<<40, data.FieldTypeInt32, false, nil, false),
+		Entry("a whole float64 to an int64", float64(1<<40), data.FieldTypeInt64, false, int64(1)<<40, true),
+		Entry("a fractional float64 to nothing for an int64", 1.5, data.FieldTypeInt64, false, nil, false),
+		Entry("a whole float64 to an int32", float64(-7), data.FieldTypeInt32, false, int32(-7), true),
+		Entry("a whole float64 to a nullable int64", float64(3), data.FieldTypeNullableInt64, false, func() *int64 { i := int64(3); return &i }(), true),
+		Entry("a fractional float64 to nothing for an int32", 0.1, data.FieldTypeInt32, false, nil, false),
+		Entry("a whole float64 too large for an int32 to nothing", float64(1<<40), data.FieldTypeInt32, false, nil, false),
+		Entry("a float64 of 2^63 to nothing for an int64", math.Pow(2, 63), data.FieldTypeInt64, false, nil, false),
+		Entry("a float64 of -2^63 to an int64", -math.Pow(2, 63), data.FieldTypeInt64, false, int64(math.MinInt64), true),
+		Entry("a NaN to nothing for an int64", math.NaN(), data.FieldTypeInt64, false, nil, false),
+		Entry("an infinity to nothing for an int64", math.Inf(1), data.FieldTypeInt64, false, nil, false),
+		Entry("a bool to nothing for a string without fallback", true, data.FieldTypeString, false, nil, false),
+		Entry("a bool to a string with fallback", true, data.FieldTypeString, true, "true", true),
+		Entry("an int32 to a nullable string with fallback", int32(3), data.FieldTypeNullableString, true, stringPointer("3"), true),
+		Entry("an int32 to JSON without fallback", int32(3), data.FieldTypeJSON, false, json.RawMessage("3"), true),
+		Entry("a document to a string with fallback", bson.D{{Key: "a", Value: int32(1)}}, data.FieldTypeString, true, `{"a":1}`, true),
+		Entry("a null to nil", nil, data.FieldTypeNullableString, false, nil, true),
+	)
+})
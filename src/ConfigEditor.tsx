@@ -59,6 +59,14 @@ export class ConfigEditor extends PureComponent<Props, State> {
     };
     onOptionsChange({ ...options, jsonData });
   };
+  onMaxConcurrentQueriesChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      maxConcurrentQueries: parseInt(event.target.value, 10),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
 
   onUsernameChange = (event: ChangeEvent<HTMLInputElement>) => {
     const { onOptionsChange, options } = this.props;
@@ -265,6 +273,22 @@ export class ConfigEditor extends PureComponent<Props, State> {
           </InlineField>
           { this.renderCredentials() }
           { this.renderTls() }
+        </FieldSet>
+        <FieldSet label="Query Execution" width={400}>
+          <InlineField
+              labelWidth={this.shortWidth}
+              label="Max Concurrent Queries"
+              tooltip="How many queries of a single panel may run against MongoDB at once. Defaults to 4"
+              >
+            <Input
+              width={this.longWidth}
+              name="maxConcurrentQueries"
+              type="number"
+              onChange={this.onMaxConcurrentQueriesChange}
+              value={jsonData.maxConcurrentQueries || ''}
+              placeholder="4"
+            ></Input>
+          </InlineField>
         </FieldSet>            
       </>
     );
@@ -14,7 +14,7 @@ import {
 } from '@grafana/ui';
 import { QueryEditorProps, SelectableValue } from '@grafana/data';
 import { DataSource } from './datasource';
-import { defaultQuery, MongoDBDataSourceOptions, MongoDBQuery, MongoDBQueryType } from './types';
+import { defaultQuery, MongoDBDataSourceOptions, MongoDBQuery, MongoDBQueryMode, MongoDBQueryType, MongoDBStreamMode, MongoDBTimestampType } from './types';
 
 type Props = QueryEditorProps<DataSource, MongoDBQuery, MongoDBDataSourceOptions>;
 
@@ -32,11 +32,133 @@ export class QueryEditor extends PureComponent<Props> {
         label: "Table",
         value: MongoDBQueryType.Table,
         description: "Return arbitrary rows for a table or further processing"
+    },
+    {
+        label: "Count",
+        value: MongoDBQueryType.Count,
+        description: "Return the number of matching documents"
+    },
+    {
+        label: "Distinct",
+        value: MongoDBQueryType.Distinct,
+        description: "Return the distinct values of a field among matching documents"
+    },
+    {
+        label: "Command",
+        value: MongoDBQueryType.Command,
+        description: "Run a read-only database command, such as dbStats or listCollections, and return its reply"
+    },
+    {
+        label: "Server Status",
+        value: MongoDBQueryType.ServerStatus,
+        description: "Sample connection, operation, memory and cache metrics from serverStatus"
+    },
+    {
+        label: "Replica Set",
+        value: MongoDBQueryType.ReplicaSet,
+        description: "Return the state, health and lag of each replica set member from replSetGetStatus"
+    },
+    {
+        label: "Index Stats",
+        value: MongoDBQueryType.IndexStats,
+        description: "Return the key, size and usage of each index of the collection, or of every collection in the database if empty"
+    },
+    {
+        label: "Current Operations",
+        value: MongoDBQueryType.CurrentOp,
+        description: "Return the operations currently running on the server, longest running first"
+    },
+    {
+        label: "Oplog",
+        value: MongoDBQueryType.Oplog,
+        description: "Count oplog entries by namespace and operation type over time, and return the current oplog window"
+    },
+    {
+        label: "Profiler",
+        value: MongoDBQueryType.Profiler,
+        description: "Summarize the operations recorded by the database profiler by query shape"
+    },
+    {
+        label: "Annotations",
+        value: MongoDBQueryType.Annotations,
+        description: "Return an annotation for each document, with its time, title, text and tags read from fields of the document"
+    },
+    {
+        label: "Logs",
+        value: MongoDBQueryType.Logs,
+        description: "Return log lines, newest first, with their body, level and labels read from fields of the document"
     }
   ];
 
+  readonly streamModeOptions = [
+    {
+        label: "Off",
+        value: MongoDBStreamMode.None,
+        description: "Run the query once for each dashboard refresh"
+    },
+    {
+        label: "Change Stream",
+        value: MongoDBStreamMode.ChangeStream,
+        description: "Push each inserted or updated document to the panel as it changes, using a change stream. Requires a replica set"
+    },
+    {
+        label: "Poll",
+        value: MongoDBStreamMode.Poll,
+        description: "Re-run the query on a fixed interval over a sliding time range, and push rows newer than those already sent. The automatic time bound is always used, and automatic time buckets cannot be"
+    },
+    {
+        label: "Tail",
+        value: MongoDBStreamMode.Tail,
+        description: "Push each document inserted into a capped collection, using a tailable cursor"
+    }
+  ];
+
+  readonly timestampTypeOptions = [
+    {
+        label: "Date",
+        value: MongoDBTimestampType.Date,
+        description: "Timestamps are BSON dates, or strings if a format is provided"
+    },
+    {
+        label: "Timestamp",
+        value: MongoDBTimestampType.Timestamp,
+        description: "Timestamps are BSON Timestamps, like the ts field of the oplog"
+    }
+  ];
+
+  readonly currentOpTypeOptions = [
+    { label: "Any", value: "" },
+    { label: "query", value: "query" },
+    { label: "command", value: "command" },
+    { label: "getmore", value: "getmore" },
+    { label: "insert", value: "insert" },
+    { label: "update", value: "update" },
+    { label: "remove", value: "remove" },
+  ];
+
+  readonly profilerRankByOptions = [
+    { label: "Total time", value: "totalMillis" },
+    { label: "Average time", value: "avgMillis" },
+    { label: "Count", value: "count" },
+    { label: "Documents examined", value: "docsExamined" },
+    { label: "Keys examined", value: "keysExamined" },
+  ];
+
   readonly defaultQueryType: MongoDBQueryType = MongoDBQueryType.Timeseries;
 
+  readonly queryModeOptions = [
+    {
+        label: "Aggregate",
+        value: MongoDBQueryMode.Aggregate,
+        description: "Run an aggregation pipeline with db.collection.aggregate(...)"
+    },
+    {
+        label: "Find",
+        value: MongoDBQueryMode.Find,
+        description: "Run db.collection.find(...) with a filter, projection, sort, skip and limit"
+    }
+  ];
+
   onDatabaseChange = (event: ChangeEvent<HTMLInputElement>) => {
     const { onChange, query } = this.props;
     onChange({ ...query, database: event.target.value });
@@ -57,6 +179,200 @@ export class QueryEditor extends PureComponent<Props> {
     onRunQuery();
   };
 
+  onQueryModeChange = (newValue: SelectableValue) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, queryMode: newValue.value });
+    onRunQuery();
+  };
+
+  onStreamModeChange = (newValue: SelectableValue) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, streamMode: newValue.value });
+    onRunQuery();
+  };
+
+  onPollIntervalSecondsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, pollIntervalSeconds: parseInt(event.target.value, 10) });
+    // executes the query
+    onRunQuery();
+  };
+
+  onPollWindowSecondsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, pollWindowSeconds: parseInt(event.target.value, 10) });
+    // executes the query
+    onRunQuery();
+  };
+
+  onTailFlushIntervalMsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, tailFlushIntervalMs: parseInt(event.target.value, 10) });
+    // executes the query
+    onRunQuery();
+  };
+
+  onTailBufferSizeChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, tailBufferSize: parseInt(event.target.value, 10) });
+    // executes the query
+    onRunQuery();
+  };
+
+  onChangeStreamMatchChange = (newMatch: string) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, changeStreamMatch: newMatch });
+    // executes the query
+    onRunQuery();
+  };
+
+  onAnnotationTimeEndFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, annotationTimeEndField: event.target.value });
+    // executes the query
+    onRunQuery();
+  };
+
+  onAnnotationTitleFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, annotationTitleField: event.target.value });
+    // executes the query
+    onRunQuery();
+  };
+
+  onAnnotationTextFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, annotationTextField: event.target.value });
+    // executes the query
+    onRunQuery();
+  };
+
+  onAnnotationTagsFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, annotationTagsField: event.target.value });
+    // executes the query
+    onRunQuery();
+  };
+
+  onLogsBodyFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, logsBodyField: event.target.value });
+    // executes the query
+    onRunQuery();
+  };
+
+  onLogsLevelFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, logsLevelField: event.target.value });
+    // executes the query
+    onRunQuery();
+  };
+
+  onFilterChange = (newFilter: string) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, filter: newFilter });
+    // executes the query
+    onRunQuery();
+  };
+
+  onProjectionChange = (newProjection: string) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, projection: newProjection });
+    // executes the query
+    onRunQuery();
+  };
+
+  onSortChange = (newSort: string) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, sort: newSort });
+    // executes the query
+    onRunQuery();
+  };
+
+  onSkipChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, skip: parseInt(event.target.value, 10) });
+    // executes the query
+    onRunQuery();
+  };
+
+  onLimitChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, limit: parseInt(event.target.value, 10) });
+    // executes the query
+    onRunQuery();
+  };
+
+  onDistinctFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, distinctField: event.target.value });
+    // executes the query
+    onRunQuery();
+  };
+
+  onCommandChange = (newCommand: string) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, command: newCommand });
+    // executes the query
+    onRunQuery();
+  };
+
+  onCommandCursorChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, commandCursor: event.currentTarget.checked });
+    // executes the query
+    onRunQuery();
+  };
+
+  onCommandResultPathChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, commandResultPath: event.target.value });
+    // executes the query
+    onRunQuery();
+  };
+
+  onServerStatusReplicationChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, serverStatusReplication: event.currentTarget.checked });
+    // executes the query
+    onRunQuery();
+  };
+
+  onCurrentOpMinSecsRunningChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, currentOpMinSecsRunning: parseInt(event.target.value, 10) });
+    // executes the query
+    onRunQuery();
+  };
+
+  onCurrentOpNamespaceChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, currentOpNamespace: event.target.value });
+    // executes the query
+    onRunQuery();
+  };
+
+  onCurrentOpTypeChange = (newValue: SelectableValue) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, currentOpType: newValue.value });
+    // executes the query
+    onRunQuery();
+  };
+
+  onProfilerTopNChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, profilerTopN: parseInt(event.target.value, 10) });
+    // executes the query
+    onRunQuery();
+  };
+
+  onProfilerRankByChange = (newValue: SelectableValue) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, profilerRankBy: newValue.value });
+    // executes the query
+    onRunQuery();
+  };
+
   onTimestampFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
     const { onChange, query, onRunQuery } = this.props;
     onChange({ ...query, timestampField: event.target.value });
@@ -72,6 +388,13 @@ export class QueryEditor extends PureComponent<Props> {
   };
 
   
+  onTimestampTypeChange = (newValue: SelectableValue) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, timestampType: newValue.value });
+    // executes the query
+    onRunQuery();
+  };
+
   onLabelFieldChange = (index: number) => (event: ChangeEvent<HTMLInputElement>) => {
     const { onChange, query, onRunQuery } = this.props;
     let newLabelFields = Array.from(query.labelFields)
@@ -106,6 +429,13 @@ export class QueryEditor extends PureComponent<Props> {
     onRunQuery();
   };
 
+  onSortByLabelsChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, sortByLabels: event.currentTarget.checked });
+    // executes the query
+    onRunQuery();
+  };
+
   onSchemaInferenceChange = (event: ChangeEvent<HTMLInputElement>) => {
     const { onChange, query, onRunQuery } = this.props;
     onChange({ ...query, schemaInference: event.target.checked });
@@ -119,6 +449,34 @@ export class QueryEditor extends PureComponent<Props> {
     onRunQuery();
   };
 
+  onSchemaInferenceFallbackToStringChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, schemaInferenceFallbackToString: event.currentTarget.checked });
+    // executes the query
+    onRunQuery();
+  };
+
+  onFlattenDocumentsChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, flattenDocuments: event.currentTarget.checked });
+    // executes the query
+    onRunQuery();
+  };
+
+  onFlattenMaxDepthChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, flattenMaxDepth: parseInt(event.target.value, 10) });
+    // executes the query
+    onRunQuery();
+  };
+
+  onExpandArrayFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, expandArrayField: event.target.value });
+    // executes the query
+    onRunQuery();
+  };
+
   onValueFieldChange = (index: number) => (event: ChangeEvent<HTMLInputElement>) => {
     const { onChange, query, onRunQuery } = this.props;
     let newValueFields = Array.from(query.valueFields);
@@ -161,83 +519,836 @@ export class QueryEditor extends PureComponent<Props> {
 
 
 
-  onAutoTimeBoundChange = (event: SyntheticEvent<HTMLInputElement>) => {
-    const { onChange, query, onRunQuery } = this.props;
-    onChange({ ...query, autoTimeBound: event.currentTarget.checked });
-    // executes the query
-    onRunQuery();
-  };
+  onAutoTimeBoundChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, autoTimeBound: event.currentTarget.checked });
+    // executes the query
+    onRunQuery();
+  };
+
+  onAutoTimeBoundAtStartChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, autoTimeBoundAtStart: event.currentTarget.checked });
+    // executes the query
+    onRunQuery();
+  };
+
+  onAutoTimeSortChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, autoTimeSort: event.currentTarget.checked });
+    // executes the query
+    onRunQuery();
+  };
+
+  onAutoBucketChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, autoBucket: event.currentTarget.checked });
+    // executes the query
+    onRunQuery();
+  };
+
+  onAutoBucketReducersChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, autoBucketReducers: event.target.value.split(',').map((reducer) => reducer.trim()) });
+    // executes the query
+    onRunQuery();
+  };
+
+  onAggregationChange = (newAggregation: string) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, aggregation: newAggregation });
+    // executes the query
+    onRunQuery();
+  };
+
+
+  renderHeader(query: MongoDBQuery) {
+    const { onChange, onRunQuery } = this.props;
+
+    return (
+      <>
+        <InlineFieldRow>
+          <InlineField labelWidth={this.labelWidth} label="Database.Collection">
+            <Input
+              width={this.longWidth}
+              name="database"
+              type="text"
+              placeholder="my_database"
+              value={query.database || ''}
+              onChange={this.onDatabaseChange}
+            ></Input>
+          </InlineField>
+          <InlineField label=".">
+            <Input
+              width={this.longWidth}
+              name="collection"
+              type="text"
+              placeholder="my_collection"
+              value={query.collection || ''}
+              onChange={this.onCollectionChange}
+            ></Input>
+          </InlineField>
+        </InlineFieldRow>
+        <InlineField
+            labelWidth={this.labelWidth}
+            tooltip="Type of query to execute"
+            label="QueryType"
+            >
+          <Select
+            options={this.queryTypeOptions}
+            value={this.queryTypeOptions.find((queryType) => queryType.value === query.queryType) ?? this.queryTypeOptions[0]}
+            onChange={this.onQueryTypeChange(query, onChange, onRunQuery)}
+              width={this.longWidth}
+          ></Select>
+        </InlineField>
+      </>
+    );
+  }
+
+  renderCountDistinct(query: MongoDBQuery) {
+    return (
+      <>
+        <FieldSet>
+          { this.renderHeader(query) }
+          { query.queryType === MongoDBQueryType.Distinct ? (
+            <InlineField
+                labelWidth={this.labelWidth}
+                label="Distinct Field"
+                tooltip="Field to find the distinct values of. Nested fields may be referenced with dot notation"
+                >
+              <Input
+                width={this.longWidth}
+                value={query.distinctField || ''}
+                onChange={this.onDistinctFieldChange}
+                type="text"
+                placeholder="name"
+              ></Input>
+            </InlineField>
+          ) : false }
+          <InlineField
+              labelWidth={this.labelWidth}
+              label="Timestamp Field"
+              tooltip="Field containing the timestamp, used for the automatic time-bound"
+              >
+            <Input
+              width={this.longWidth}
+              value={query.timestampField || ''}
+              onChange={this.onTimestampFieldChange}
+              type="text"
+              placeholder="timestamp"
+            ></Input>
+          </InlineField>
+          <InlineField
+              label="Automatic Time-Bound"
+              labelWidth={this.labelWidth}
+              tooltip="Only consider documents where Timestamp Field is within the current dashboard time range"
+              >
+            <InlineSwitch
+              value={query.autoTimeBound || false}
+              onChange={this.onAutoTimeBoundChange}
+            ></InlineSwitch>
+          </InlineField>
+        </FieldSet>
+        <InlineFormLabel
+          width={this.labelWidth}
+          tooltip="Optional filter document, as Extended JSON"
+        >
+          Filter
+        </InlineFormLabel>
+        <CodeEditor
+          height="150px"
+          showLineNumbers={true}
+          language="json"
+          value={query.filter || ''}
+          onBlur={this.onFilterChange}
+        ></CodeEditor>
+      </>
+    );
+  }
+
+  renderCommand(query: MongoDBQuery) {
+    return (
+      <>
+        <FieldSet>
+          { this.renderHeader(query) }
+          <InlineField
+              label="Returns Cursor"
+              labelWidth={this.labelWidth}
+              tooltip="Enable for commands which return a cursor, such as listCollections or listIndexes, to return every document from the cursor"
+              >
+            <InlineSwitch
+              value={query.commandCursor || false}
+              onChange={this.onCommandCursorChange}
+            ></InlineSwitch>
+          </InlineField>
+          { !query.commandCursor ? (
+            <InlineField
+                labelWidth={this.labelWidth}
+                label="Result Path"
+                tooltip="Optional path within the reply to return, in dot notation. If it is an array, each element becomes a row. If empty, the whole reply, without its ok, operationTime and $clusterTime fields, is returned as a single row"
+                >
+              <Input
+                width={this.longWidth}
+                value={query.commandResultPath || ''}
+                onChange={this.onCommandResultPathChange}
+                type="text"
+                placeholder="cursor.firstBatch"
+              ></Input>
+            </InlineField>
+          ) : false }
+        </FieldSet>
+        <InlineFormLabel
+          width={this.labelWidth}
+          tooltip="Argument to db.runCommand(...), as Extended JSON. The command is run against the selected database, and the collection is ignored. Only read-only commands, such as dbStats, collStats, listCollections, listIndexes, serverStatus or hostInfo, are allowed"
+        >
+          Command
+        </InlineFormLabel>
+        <div 
+          style={{ resize: "vertical" }}
+        >
+          <CodeEditor
+            height="150px"
+            showLineNumbers={true}
+            language="json"
+            value={query.command || ''}
+            onBlur={this.onCommandChange}
+          ></CodeEditor>
+        </div>
+      </>
+    );
+  }
+
+  renderServerStatus(query: MongoDBQuery) {
+    return (
+      <FieldSet>
+        { this.renderHeader(query) }
+        <InlineField
+            label="Replication"
+            labelWidth={this.labelWidth}
+            tooltip="Also sample replSetGetStatus for the number of healthy members and the maximum lag of any secondary. Requires a replica set"
+            >
+          <InlineSwitch
+            value={query.serverStatusReplication || false}
+            onChange={this.onServerStatusReplicationChange}
+          ></InlineSwitch>
+        </InlineField>
+        { this.renderStreamMode(query, this.streamModeOptions.filter((option) => option.value === MongoDBStreamMode.None || option.value === MongoDBStreamMode.Poll)) }
+      </FieldSet>
+    );
+  }
+
+  renderCurrentOp(query: MongoDBQuery) {
+    return (
+      <FieldSet>
+        { this.renderHeader(query) }
+        <InlineField
+            labelWidth={this.labelWidth}
+            label="Min Seconds Running"
+            tooltip="Only return operations which have been running for at least this many seconds"
+            >
+          <Input
+            value={`${query.currentOpMinSecsRunning || 0}`}
+            onChange={this.onCurrentOpMinSecsRunningChange}
+            type="number"
+          />
+        </InlineField>
+        <InlineField
+            labelWidth={this.labelWidth}
+            label="Namespace"
+            tooltip="Only return operations on this namespace, as database.collection, or on any collection in this database"
+            >
+          <Input
+            width={this.longWidth}
+            value={query.currentOpNamespace || ''}
+            onChange={this.onCurrentOpNamespaceChange}
+            type="text"
+            placeholder="my_db.my_collection"
+          ></Input>
+        </InlineField>
+        <InlineField
+            labelWidth={this.labelWidth}
+            label="Operation Type"
+            tooltip="Only return operations of this type"
+            >
+          <Select
+            options={this.currentOpTypeOptions}
+            value={this.currentOpTypeOptions.find((opType) => opType.value === (query.currentOpType || '')) ?? this.currentOpTypeOptions[0]}
+            onChange={this.onCurrentOpTypeChange}
+            width={this.longWidth}
+          ></Select>
+        </InlineField>
+      </FieldSet>
+    );
+  }
+
+  renderProfiler(query: MongoDBQuery) {
+    return (
+      <FieldSet>
+        { this.renderHeader(query) }
+        <InlineField
+            labelWidth={this.labelWidth}
+            label="Top Shapes"
+            tooltip="Number of query shapes to include in the table, ranked by the statistic below"
+            >
+          <Input
+            value={`${query.profilerTopN || 10}`}
+            onChange={this.onProfilerTopNChange}
+            type="number"
+          />
+        </InlineField>
+        <InlineField
+            labelWidth={this.labelWidth}
+            label="Rank By"
+            tooltip="Statistic of each query shape to rank the table by, highest first"
+            >
+          <Select
+            options={this.profilerRankByOptions}
+            value={this.profilerRankByOptions.find((rankBy) => rankBy.value === query.profilerRankBy) ?? this.profilerRankByOptions[0]}
+            onChange={this.onProfilerRankByChange}
+            width={this.longWidth}
+          ></Select>
+        </InlineField>
+      </FieldSet>
+    );
+  }
+
+  renderAnnotations(query: MongoDBQuery) {
+    return (
+      <>
+        <FieldSet>
+          { this.renderHeader(query) }
+          <InlineField
+              labelWidth={this.labelWidth}
+              tooltip="How to query the collection"
+              label="Query Mode"
+              >
+            <Select
+              options={this.queryModeOptions}
+              value={this.queryModeOptions.find((queryMode) => queryMode.value === query.queryMode) ?? this.queryModeOptions[0]}
+              onChange={this.onQueryModeChange}
+              width={this.longWidth}
+            ></Select>
+          </InlineField>
+          <InlineField
+              labelWidth={this.labelWidth}
+              label="Timestamp Field"
+              tooltip="Field to expect in every document containing the time of the annotation. Nested fields may be referenced with dot notation"
+              >
+            <Input
+              width={this.longWidth}
+              value={query.timestampField || ''}
+              onChange={this.onTimestampFieldChange}
+              type="text"
+              placeholder="timestamp"
+            ></Input>
+          </InlineField>
+          <InlineField
+              labelWidth={this.labelWidth}
+              label="Timestamp Type"
+              tooltip="BSON type of the timestamp field"
+              >
+            <Select
+              options={this.timestampTypeOptions}
+              value={this.timestampTypeOptions.find((timestampType) => timestampType.value === query.timestampType) ?? this.timestampTypeOptions[0]}
+              onChange={this.onTimestampTypeChange}
+              width={this.longWidth}
+            ></Select>
+          </InlineField>
+          <InlineField
+              labelWidth={this.labelWidth}
+              label="Timestamp Format"
+              tooltip="If blank, assume timestamps are native BSON dates. Otherwise, parse the timestamp as a string in the format described here: https://pkg.go.dev/time#Parse"
+              >
+            <Input
+              width={this.longWidth}
+              value={query.timestampFormat || ''}
+              onChange={this.onTimestampFormatChange}
+              type="text"
+              placeholder="<BSON $date>"
+            ></Input>
+          </InlineField>
+          <InlineField
+              labelWidth={this.labelWidth}
+              label="Time End Field"
+              tooltip="Optional field containing the end of the annotation, which makes it a region. Must have the same type and format as Timestamp Field"
+              >
+            <Input
+              width={this.longWidth}
+              value={query.annotationTimeEndField || ''}
+              onChange={this.onAnnotationTimeEndFieldChange}
+              type="text"
+              placeholder="endTimestamp"
+            ></Input>
+          </InlineField>
+          <InlineField
+              labelWidth={this.labelWidth}
+              label="Title Field"
+              tooltip="Optional field containing the title of the annotation"
+              >
+            <Input
+              width={this.longWidth}
+              value={query.annotationTitleField || ''}
+              onChange={this.onAnnotationTitleFieldChange}
+              type="text"
+              placeholder="title"
+            ></Input>
+          </InlineField>
+          <InlineField
+              labelWidth={this.labelWidth}
+              label="Text Field"
+              tooltip="Optional field containing the text of the annotation"
+              >
+            <Input
+              width={this.longWidth}
+              value={query.annotationTextField || ''}
+              onChange={this.onAnnotationTextFieldChange}
+              type="text"
+              placeholder="message"
+            ></Input>
+          </InlineField>
+          <InlineField
+              labelWidth={this.labelWidth}
+              label="Tags Field"
+              tooltip="Optional field containing the tags of the annotation. Each element of an array becomes a tag, and any other value becomes a single tag. Tags cannot contain commas"
+              >
+            <Input
+              width={this.longWidth}
+              value={query.annotationTagsField || ''}
+              onChange={this.onAnnotationTagsFieldChange}
+              type="text"
+              placeholder="tags"
+            ></Input>
+          </InlineField>
+          <InlineField
+              label="Automatic Time-Bound"
+              labelWidth={this.labelWidth}
+              tooltip="Only return annotations where Timestamp Field is within the current dashboard time range"
+              >
+            <InlineSwitch
+              value={query.autoTimeBound || false}
+              onChange={this.onAutoTimeBoundChange}
+            ></InlineSwitch>
+          </InlineField>
+          { query.autoTimeBound ? (
+            <InlineField
+                label="Time-Bound at Start"
+                labelWidth={this.labelWidth}
+                tooltip="Add the automatic time bound $match stage at the beginning of your pipeline instead of the end"
+                >
+              <InlineSwitch
+                value={query.autoTimeBoundAtStart || false}
+                onChange={this.onAutoTimeBoundAtStartChange}
+              ></InlineSwitch>
+            </InlineField>
+          ) : false }
+        </FieldSet>
+        { (query.queryMode || MongoDBQueryMode.Aggregate) === MongoDBQueryMode.Find ? this.renderFind(query)
+          : this.renderAggregation(query) }
+      </>
+    );
+  }
+
+  renderLogs(query: MongoDBQuery) {
+    return (
+      <>
+        <FieldSet>
+          { this.renderHeader(query) }
+          <InlineField
+              labelWidth={this.labelWidth}
+              tooltip="How to query the collection"
+              label="Query Mode"
+              >
+            <Select
+              options={this.queryModeOptions}
+              value={this.queryModeOptions.find((queryMode) => queryMode.value === query.queryMode) ?? this.queryModeOptions[0]}
+              onChange={this.onQueryModeChange}
+              width={this.longWidth}
+            ></Select>
+          </InlineField>
+          { this.renderStreamMode(query, this.streamModeOptions) }
+          <InlineField
+              labelWidth={this.labelWidth}
+              label="Timestamp Field"
+              tooltip="Field to expect in every document containing the time of the log line. Nested fields may be referenced with dot notation"
+              >
+            <Input
+              width={this.longWidth}
+              value={query.timestampField || ''}
+              onChange={this.onTimestampFieldChange}
+              type="text"
+              placeholder="timestamp"
+            ></Input>
+          </InlineField>
+          <InlineField
+              labelWidth={this.labelWidth}
+              label="Timestamp Type"
+              tooltip="BSON type of the timestamp field"
+              >
+            <Select
+              options={this.timestampTypeOptions}
+              value={this.timestampTypeOptions.find((timestampType) => timestampType.value === query.timestampType) ?? this.timestampTypeOptions[0]}
+              onChange={this.onTimestampTypeChange}
+              width={this.longWidth}
+            ></Select>
+          </InlineField>
+          <InlineField
+              labelWidth={this.labelWidth}
+              label="Timestamp Format"
+              tooltip="If blank, assume timestamps are native BSON dates. Otherwise, parse the timestamp as a string in the format described here: https://pkg.go.dev/time#Parse"
+              >
+            <Input
+              width={this.longWidth}
+              value={query.timestampFormat || ''}
+              onChange={this.onTimestampFormatChange}
+              type="text"
+              placeholder="<BSON $date>"
+            ></Input>
+          </InlineField>
+          <InlineField
+              labelWidth={this.labelWidth}
+              label="Body Field"
+              tooltip="Field containing the message of the log line. Values which are not strings are shown as JSON"
+              >
+            <Input
+              width={this.longWidth}
+              value={query.logsBodyField || ''}
+              onChange={this.onLogsBodyFieldChange}
+              type="text"
+              placeholder="message"
+            ></Input>
+          </InlineField>
+          <InlineField
+              labelWidth={this.labelWidth}
+              label="Level Field"
+              tooltip="Optional field containing the level of the log line, such as error, warn, info or debug, which is used to color it"
+              >
+            <Input
+              width={this.longWidth}
+              value={query.logsLevelField || ''}
+              onChange={this.onLogsLevelFieldChange}
+              type="text"
+              placeholder="level"
+            ></Input>
+          </InlineField>
+          <InlineFormLabel
+              width={this.labelWidth}
+              tooltip="Fields to attach to each log line as labels, which can be used to filter the logs. Nested fields may be referenced with dot notation"
+          >
+            Label Fields
+          </InlineFormLabel>
+          <div>
+              {query.labelFields.map((field, index) => (
+                  <InlineFieldRow key={index}>
+                      <Input
+                        width={this.longWidth}
+                        onChange={this.onLabelFieldChange(index)}
+                        value={field}
+                        placeholder="name"
+                      ></Input>
+                      <Button onClick={this.onLabelFieldRemove(index)}>-</Button>
+                  </InlineFieldRow>
+              ))}
+              <Button onClick={this.onLabelFieldAppend}>+</Button>
+          </div>
+          { (query.queryMode || MongoDBQueryMode.Aggregate) !== MongoDBQueryMode.Find ? (
+            <InlineField
+                labelWidth={this.labelWidth}
+                label="Row Limit"
+                tooltip="Most log lines to return, newest first. 0 means 1000"
+                >
+              <Input
+                value={`${query.limit || 0}`}
+                onChange={this.onLimitChange}
+                type="number"
+              />
+            </InlineField>
+          ) : false }
+          <InlineField
+              label="Automatic Time-Bound"
+              labelWidth={this.labelWidth}
+              tooltip="Only return log lines where Timestamp Field is within the current dashboard time range"
+              >
+            <InlineSwitch
+              value={query.autoTimeBound || false}
+              onChange={this.onAutoTimeBoundChange}
+            ></InlineSwitch>
+          </InlineField>
+          { query.autoTimeBound ? (
+            <InlineField
+                label="Time-Bound at Start"
+                labelWidth={this.labelWidth}
+                tooltip="Add the automatic time bound $match stage at the beginning of your pipeline instead of the end"
+                >
+              <InlineSwitch
+                value={query.autoTimeBoundAtStart || false}
+                onChange={this.onAutoTimeBoundAtStartChange}
+              ></InlineSwitch>
+            </InlineField>
+          ) : false }
+        </FieldSet>
+        { query.streamMode === MongoDBStreamMode.ChangeStream ? this.renderChangeStream(query)
+          : query.streamMode === MongoDBStreamMode.Tail ? this.renderTail(query)
+          : (query.queryMode || MongoDBQueryMode.Aggregate) === MongoDBQueryMode.Find ? this.renderFind(query)
+          : this.renderAggregation(query) }
+      </>
+    );
+  }
+
+  renderStreamMode(query: MongoDBQuery, options: Array<SelectableValue<MongoDBStreamMode>>) {
+    return (
+      <>
+        <InlineField
+            labelWidth={this.labelWidth}
+            tooltip="Whether to push new results to the panel over Grafana Live instead of only on refresh"
+            label="Stream"
+            >
+          <Select
+            options={options}
+            value={options.find((streamMode) => streamMode.value === (query.streamMode || MongoDBStreamMode.None)) ?? options[0]}
+            onChange={this.onStreamModeChange}
+            width={this.longWidth}
+          ></Select>
+        </InlineField>
+        { query.streamMode === MongoDBStreamMode.Poll ? (
+          <InlineFieldRow>
+            <InlineField
+                labelWidth={this.labelWidth}
+                label="Poll Interval"
+                tooltip="How often to re-run the query, in seconds"
+                >
+              <Input
+                value={`${query.pollIntervalSeconds || 10}`}
+                onChange={this.onPollIntervalSecondsChange}
+                type="number"
+              />
+            </InlineField>
+            <InlineField
+                label="Window"
+                tooltip="How far back from the current time to query each time, in seconds. Used by the automatic time bound and time macros"
+                >
+              <Input
+                value={`${query.pollWindowSeconds || 300}`}
+                onChange={this.onPollWindowSecondsChange}
+                type="number"
+              />
+            </InlineField>
+          </InlineFieldRow>
+        ) : false }
+        { query.streamMode === MongoDBStreamMode.Tail ? (
+          <InlineFieldRow>
+            <InlineField
+                labelWidth={this.labelWidth}
+                label="Flush Interval"
+                tooltip="The longest a new document waits to be sent to the panel, in milliseconds"
+                >
+              <Input
+                value={`${query.tailFlushIntervalMs || 1000}`}
+                onChange={this.onTailFlushIntervalMsChange}
+                type="number"
+              />
+            </InlineField>
+            <InlineField
+                label="Buffer Size"
+                tooltip="The most new documents to send to the panel at once"
+                >
+              <Input
+                value={`${query.tailBufferSize || 1000}`}
+                onChange={this.onTailBufferSizeChange}
+                type="number"
+              />
+            </InlineField>
+          </InlineFieldRow>
+        ) : false }
+      </>
+    );
+  }
 
-  onAutoTimeBoundAtStartChange = (event: SyntheticEvent<HTMLInputElement>) => {
-    const { onChange, query, onRunQuery } = this.props;
-    onChange({ ...query, autoTimeBoundAtStart: event.currentTarget.checked });
-    // executes the query
-    onRunQuery();
-  };
+  renderTail(query: MongoDBQuery) {
+    return (
+      <>
+        <InlineFormLabel
+          width={this.labelWidth}
+          tooltip="Optional filter document for new documents, as Extended JSON"
+        >
+          Filter
+        </InlineFormLabel>
+        <CodeEditor
+          height="150px"
+          showLineNumbers={true}
+          language="json"
+          value={query.filter || ''}
+          onBlur={this.onFilterChange}
+        ></CodeEditor>
+      </>
+    );
+  }
 
-  onAutoTimeSortChange = (event: SyntheticEvent<HTMLInputElement>) => {
-    const { onChange, query, onRunQuery } = this.props;
-    onChange({ ...query, autoTimeSort: event.currentTarget.checked });
-    // executes the query
-    onRunQuery();
-  };
+  renderChangeStream(query: MongoDBQuery) {
+    return (
+      <>
+        <InlineFormLabel
+          width={this.labelWidth}
+          tooltip="Optional filter on change events, as Extended JSON, used as a $match stage of the change stream, e.g. {&quot;operationType&quot;: &quot;insert&quot;}. The full document of each matching event is sent to the panel"
+        >
+          Change Stream Match
+        </InlineFormLabel>
+        <CodeEditor
+          height="100px"
+          showLineNumbers={true}
+          language="json"
+          value={query.changeStreamMatch || ''}
+          onBlur={this.onChangeStreamMatchChange}
+        ></CodeEditor>
+      </>
+    );
+  }
 
-  onAggregationChange = (newAggregation: string) => {
-    const { onChange, query, onRunQuery } = this.props;
-    onChange({ ...query, aggregation: newAggregation });
-    // executes the query
-    onRunQuery();
-  };
+  renderAggregation(query: MongoDBQuery) {
+    return (
+      <>
+        <InlineFormLabel
+          width={this.labelWidth}
+          tooltip="Argument to db.collection.aggregate(...), a JSON array of pipeline stage objects. Helper functions like new Date() or ObjectId() are not supported, consult the MongoDB manual at https://www.mongodb.com/docs/manual/reference/mongodb-extended-json/ to see how to represent these functions in pure JSON"
+        >
+          Aggregation
+        </InlineFormLabel>
+        <div 
+          style={{ resize: "vertical" }}
+        >
+          <CodeEditor
+            height="300px"
+            showLineNumbers={true}
+            language="json"
+            value={query.aggregation || ''}
+            onBlur={this.onAggregationChange}
+          ></CodeEditor>
+        </div>
+      </>
+    );
+  }
 
+  renderFind(query: MongoDBQuery) {
+    return (
+      <>
+        <InlineFormLabel
+          width={this.labelWidth}
+          tooltip="Filter argument to db.collection.find(...), as Extended JSON. Consult the MongoDB manual at https://www.mongodb.com/docs/manual/reference/mongodb-extended-json/ to see how to represent helper functions in pure JSON"
+        >
+          Filter
+        </InlineFormLabel>
+        <div 
+          style={{ resize: "vertical" }}
+        >
+          <CodeEditor
+            height="150px"
+            showLineNumbers={true}
+            language="json"
+            value={query.filter || ''}
+            onBlur={this.onFilterChange}
+          ></CodeEditor>
+        </div>
+        <InlineFormLabel
+          width={this.labelWidth}
+          tooltip="Optional projection document, as Extended JSON"
+        >
+          Projection
+        </InlineFormLabel>
+        <CodeEditor
+          height="75px"
+          showLineNumbers={true}
+          language="json"
+          value={query.projection || ''}
+          onBlur={this.onProjectionChange}
+        ></CodeEditor>
+        <InlineFormLabel
+          width={this.labelWidth}
+          tooltip="Optional sort document, as Extended JSON"
+        >
+          Sort
+        </InlineFormLabel>
+        <CodeEditor
+          height="75px"
+          showLineNumbers={true}
+          language="json"
+          value={query.sort || ''}
+          onBlur={this.onSortChange}
+        ></CodeEditor>
+        <InlineFieldRow>
+          <InlineField labelWidth={this.labelWidth} label="Skip">
+            <Input
+              value={`${query.skip || 0}`}
+              onChange={this.onSkipChange}
+              type="number"
+            />
+          </InlineField>
+          <InlineField label="Limit" tooltip="0 means no limit">
+            <Input
+              value={`${query.limit || 0}`}
+              onChange={this.onLimitChange}
+              type="number"
+            />
+          </InlineField>
+        </InlineFieldRow>
+      </>
+    );
+  }
 
   render() {
     const query = defaults(this.props.query, defaultQuery);
-    const { onChange, onRunQuery } = this.props;
+
+    if (query.queryType === MongoDBQueryType.Count || query.queryType === MongoDBQueryType.Distinct) {
+      return this.renderCountDistinct(query);
+    }
+    if (query.queryType === MongoDBQueryType.Command) {
+      return this.renderCommand(query);
+    }
+    if (query.queryType === MongoDBQueryType.ServerStatus) {
+      return this.renderServerStatus(query);
+    }
+    if (query.queryType === MongoDBQueryType.Profiler) {
+      return this.renderProfiler(query);
+    }
+    if (query.queryType === MongoDBQueryType.CurrentOp) {
+      return this.renderCurrentOp(query);
+    }
+    if (query.queryType === MongoDBQueryType.Annotations) {
+      return this.renderAnnotations(query);
+    }
+    if (query.queryType === MongoDBQueryType.Logs) {
+      return this.renderLogs(query);
+    }
+    if (query.queryType === MongoDBQueryType.ReplicaSet || query.queryType === MongoDBQueryType.IndexStats || query.queryType === MongoDBQueryType.Oplog) {
+      return (
+        <FieldSet>
+          { this.renderHeader(query) }
+        </FieldSet>
+      );
+    }
 
     return (
       <>
         <FieldSet>
-          <InlineFieldRow>
-            <InlineField labelWidth={this.labelWidth} label="Database.Collection">
-              <Input
-                width={this.longWidth}
-                name="database"
-                type="text"
-                placeholder="my_database"
-                value={query.database || ''}
-                onChange={this.onDatabaseChange}
-              ></Input>
-            </InlineField>
-            <InlineField label=".">
-              <Input
-                width={this.longWidth}
-                name="collection"
-                type="text"
-                placeholder="my_collection"
-                value={query.collection || ''}
-                onChange={this.onCollectionChange}
-              ></Input>
-            </InlineField>
-          </InlineFieldRow>
+          { this.renderHeader(query) }
           <InlineField
               labelWidth={this.labelWidth}
-              tooltip="Type of query to execute"
-              label="QueryType"
+              tooltip="How to query the collection"
+              label="Query Mode"
               >
             <Select
-              options={this.queryTypeOptions}
-              value={this.queryTypeOptions.find((queryType) => queryType.value === query.queryType) ?? this.queryTypeOptions[0]}
-              onChange={this.onQueryTypeChange(query, onChange, onRunQuery)}
-                width={this.longWidth}
+              options={this.queryModeOptions}
+              value={this.queryModeOptions.find((queryMode) => queryMode.value === query.queryMode) ?? this.queryModeOptions[0]}
+              onChange={this.onQueryModeChange}
+              width={this.longWidth}
             ></Select>
           </InlineField>
+          { this.renderStreamMode(query, this.streamModeOptions) }
 
           { (query.queryType || this.defaultQueryType) === MongoDBQueryType.Timeseries ? (
             <>
               <InlineField
                   labelWidth={this.labelWidth}
                   label="Timestamp Field"
-                  tooltip="Field to expect in every document containing the timestamp"
+                  tooltip="Field to expect in every document containing the timestamp. Nested fields may be referenced with dot notation"
                   >
                 <Input
                   width={this.longWidth}
@@ -248,6 +1359,18 @@ export class QueryEditor extends PureComponent<Props> {
                   name="timestampField"
                 ></Input>
               </InlineField>
+              <InlineField
+                  labelWidth={this.labelWidth}
+                  label="Timestamp Type"
+                  tooltip="BSON type of the timestamp field"
+                  >
+                <Select
+                  options={this.timestampTypeOptions}
+                  value={this.timestampTypeOptions.find((timestampType) => timestampType.value === query.timestampType) ?? this.timestampTypeOptions[0]}
+                  onChange={this.onTimestampTypeChange}
+                  width={this.longWidth}
+                ></Select>
+              </InlineField>
               <InlineField
                   labelWidth={this.labelWidth}
                   label="Timestamp Format"
@@ -264,7 +1387,7 @@ export class QueryEditor extends PureComponent<Props> {
               </InlineField>
               <InlineFormLabel
                   width={this.labelWidth}
-                  tooltip="Each unique combination of these fields defines a separate time series. Nested fields are not supported, please project to a flat document"
+                  tooltip="Each unique combination of these fields defines a separate time series. Nested fields may be referenced with dot notation, e.g. metadata.sensorId or readings.0.value"
               >
                 Label Fields
               </InlineFormLabel>
@@ -292,6 +1415,16 @@ export class QueryEditor extends PureComponent<Props> {
                   onChange={this.onLegendFormatChange}
                 />
               </InlineField>
+              <InlineField
+                  label="Sort Series by Labels"
+                  labelWidth={this.labelWidth}
+                  tooltip="Return series ordered by their label values instead of the order they first appear in the results. Values which are both numbers are compared as numbers"
+                  >
+                <InlineSwitch
+                  value={query.sortByLabels || false}
+                  onChange={this.onSortByLabelsChange}
+                ></InlineSwitch>
+              </InlineField>
             </>
           ) : false }
           { (query.queryType || this.defaultQueryType) === MongoDBQueryType.Timeseries ? (
@@ -328,9 +1461,72 @@ export class QueryEditor extends PureComponent<Props> {
                   onChange={this.onAutoTimeSortChange}
                 ></InlineSwitch>
               </InlineField>
+              <InlineField
+                  label="Automatic Time-Buckets"
+                  labelWidth={this.labelWidth}
+                  tooltip="Add stages at the end to $group documents into time buckets sized from the panel interval and max data points, per unique combination of labels. Averaged values are always returned as float64"
+                  >
+                <InlineSwitch
+                  value={query.autoBucket || false}
+                  onChange={this.onAutoBucketChange}
+                ></InlineSwitch>
+              </InlineField>
+              { query.autoBucket ? (
+                <InlineField
+                    label="Bucket Reducers"
+                    labelWidth={this.labelWidth}
+                    tooltip="Comma-separated reducer for each value field, in order: avg, sum, min, max, count, first, or last. Defaults to avg"
+                    >
+                  <Input
+                    width={this.longWidth}
+                    value={(query.autoBucketReducers || []).join(',')}
+                    onChange={this.onAutoBucketReducersChange}
+                    type="text"
+                    placeholder="avg"
+                  ></Input>
+                </InlineField>
+              ) : false }
             </>
           ) : false }
 
+          <InlineField
+              label="Flatten Documents"
+              labelWidth={this.labelWidth}
+              tooltip="Expand embedded documents into one field per nested field, named parent.child, instead of a single JSON field"
+              >
+            <InlineSwitch
+              value={query.flattenDocuments || false}
+              onChange={this.onFlattenDocumentsChange}
+            ></InlineSwitch>
+          </InlineField>
+          { query.flattenDocuments ? (
+            <InlineField
+                label="Flatten Max Depth"
+                labelWidth={this.labelWidth}
+                tooltip="How many levels of embedded documents to expand. Documents nested deeper are returned as JSON. 0 means no limit"
+                >
+              <Input
+                value={`${query.flattenMaxDepth || 0}`}
+                onChange={this.onFlattenMaxDepthChange}
+                type="number"
+              />
+            </InlineField>
+          ) : false }
+
+          <InlineField
+              label="Expand Array Field"
+              labelWidth={this.labelWidth}
+              tooltip="For documents using the bucket pattern, the name of an array field whose elements should each become a row. The fields of each element are merged with the fields of the parent document, which can then be used as labels. Cannot be used with the automatic time bound, time sort or time buckets"
+              >
+            <Input
+              width={this.longWidth}
+              value={query.expandArrayField || ''}
+              onChange={this.onExpandArrayFieldChange}
+              type="text"
+              placeholder="samples"
+            ></Input>
+          </InlineField>
+
           <div className="gf-form">
             <InlineFormLabel
               width={this.labelWidth}
@@ -349,7 +1545,7 @@ export class QueryEditor extends PureComponent<Props> {
               <InlineField
                     labelWidth={this.labelWidth}
                     label="Schema Inference Depth"
-                    tooltip="How many documents to consider for inference before assuming no new fields will be present. If all documents have the same fields, you can set this to 1"
+                    tooltip="How many documents to consider for inference before building the result. Fields which are missing or new in later documents are handled by revising the schema, so this only affects performance. If all documents have the same fields, you can set this to 1"
               >
                 <Input
                     value={`${query.schemaInferenceDepth}`}
@@ -357,12 +1553,22 @@ export class QueryEditor extends PureComponent<Props> {
                     type="number"
                 />
               </InlineField>
+              <InlineField
+                    labelWidth={this.labelWidth}
+                    label="Fall Back to String"
+                    tooltip="Numeric fields which appear with different types (e.g. int32 and float64) are always widened. If enabled, fields with otherwise incompatible types are converted to strings (or JSON) instead of failing the query"
+              >
+                <InlineSwitch
+                    value={query.schemaInferenceFallbackToString || false}
+                    onChange={this.onSchemaInferenceFallbackToStringChange}
+                />
+              </InlineField>
             </>
             :
             <>
               <InlineFormLabel
                 width={this.labelWidth}
-                tooltip="These fields contain measurements or other recorded values. You must also specify the data types (float64, uint64, string, etc) for each field. Prefix with a star if a field may not appear in every document for a given series. See https://pkg.go.dev/github.com/grafana/grafana-plugin-sdk-go/data#FieldType for a list of valid types. Nested fields are not supported, please project to a flat document"
+                tooltip="These fields contain measurements or other recorded values. You must also specify the data types (float64, uint64, string, etc) for each field. Prefix with a star if a field may not appear in every document for a given series. See https://pkg.go.dev/github.com/grafana/grafana-plugin-sdk-go/data#FieldType for a list of valid types. Nested fields may be referenced with dot notation, e.g. metadata.sensorId or readings.0.value"
               >Value Fields</InlineFormLabel>
               {zip(query.valueFields, query.valueFieldTypes).map((field, index) => (
                   <InlineFieldRow key={index}>
@@ -388,23 +1594,10 @@ export class QueryEditor extends PureComponent<Props> {
           }
 
         </FieldSet>
-        <InlineFormLabel
-          width={this.labelWidth}
-          tooltip="Argument to db.collection.aggregate(...), a JSON array of pipeline stage objects. Helper functions like new Date() or ObjectId() are not supported, consult the MongoDB manual at https://www.mongodb.com/docs/manual/reference/mongodb-extended-json/ to see how to represent these functions in pure JSON"
-        >
-          Aggregation
-        </InlineFormLabel>
-        <div 
-          style={{ resize: "vertical" }}
-        >
-          <CodeEditor
-            height="300px"
-            showLineNumbers={true}
-            language="json"
-            value={query.aggregation || ''}
-            onBlur={this.onAggregationChange}
-          ></CodeEditor>
-        </div>
+        { query.streamMode === MongoDBStreamMode.ChangeStream ? this.renderChangeStream(query)
+          : query.streamMode === MongoDBStreamMode.Tail ? this.renderTail(query)
+          : (query.queryMode || MongoDBQueryMode.Aggregate) === MongoDBQueryMode.Find ? this.renderFind(query)
+          : this.renderAggregation(query) }
       </>
     );
   }
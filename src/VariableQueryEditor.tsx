@@ -1,4 +1,4 @@
-import { MongoDBVariableQuery, defaultVariableQuery } from './types';
+import { MongoDBVariableQuery, MongoDBVariableQueryType, defaultVariableQuery } from './types';
 import { defaults } from 'lodash';
 import React, { ChangeEvent, PureComponent } from 'react';
 import { 
@@ -7,7 +7,9 @@ import {
   InlineFormLabel,
   InlineFieldRow,
   CodeEditor,
+  Select,
 } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
 
 
 interface VariableQueryProps {
@@ -19,6 +21,24 @@ export class VariableQueryEditor extends PureComponent<VariableQueryProps> {
   readonly labelWidth = 25;
   readonly longWidth = 50;
 
+  readonly queryTypeOptions = [
+    {
+        label: "Aggregate",
+        value: MongoDBVariableQueryType.Aggregate,
+        description: "Run an aggregation pipeline, and use the values of a field from the results"
+    },
+    {
+        label: "Distinct",
+        value: MongoDBVariableQueryType.Distinct,
+        description: "Use the distinct values of a field among documents matching a filter"
+    },
+    {
+        label: "Count",
+        value: MongoDBVariableQueryType.Count,
+        description: "Use the number of documents matching a filter as the only value"
+    }
+  ];
+
   onDatabaseChange = (event: ChangeEvent<HTMLInputElement>) => {
     const { onChange, query } = this.props;
     onChange({ ...query, database: event.target.value });
@@ -29,6 +49,16 @@ export class VariableQueryEditor extends PureComponent<VariableQueryProps> {
     onChange({ ...query, collection: event.target.value });
   };
 
+  onQueryTypeChange = (newValue: SelectableValue) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, queryType: newValue.value });
+  };
+
+  onFilterChange = (newFilter: string) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, filter: newFilter });
+  };
+
   onFieldNameChange = (event: ChangeEvent<HTMLInputElement>) => {
     const { onChange, query } = this.props;
     onChange({ ...query, fieldName: event.target.value });
@@ -77,43 +107,79 @@ export class VariableQueryEditor extends PureComponent<VariableQueryProps> {
           </InlineField>
         </InlineFieldRow>
   
-        <InlineFieldRow>
-           <InlineField
-               label="Field"
-               labelWidth={this.labelWidth}
-               >
-             <Input
-               width={this.longWidth}
-               placeholder="name"
-               onChange={this.onFieldNameChange}
-               value={query.fieldName}
-             ></Input>
-           </InlineField>
-           <InlineField
-              label=":"
-              >
-            <Input
-              width={this.longWidth}
-              placeholder="type"
-              onChange={this.onFieldTypeChange}
-              value={query.fieldType}
-            ></Input>
-          </InlineField>
-        </InlineFieldRow>
+        <InlineField
+            labelWidth={this.labelWidth}
+            label="Query Type"
+            >
+          <Select
+            options={this.queryTypeOptions}
+            value={this.queryTypeOptions.find((queryType) => queryType.value === query.queryType) ?? this.queryTypeOptions[0]}
+            onChange={this.onQueryTypeChange}
+            width={this.longWidth}
+          ></Select>
+        </InlineField>
+
+        { query.queryType !== MongoDBVariableQueryType.Count && (
+          <InlineFieldRow>
+             <InlineField
+                 label="Field"
+                 labelWidth={this.labelWidth}
+                 >
+               <Input
+                 width={this.longWidth}
+                 placeholder="name"
+                 onChange={this.onFieldNameChange}
+                 value={query.fieldName}
+               ></Input>
+             </InlineField>
+             { query.queryType !== MongoDBVariableQueryType.Distinct && (
+               <InlineField
+                  label=":"
+                  >
+                <Input
+                  width={this.longWidth}
+                  placeholder="type"
+                  onChange={this.onFieldTypeChange}
+                  value={query.fieldType}
+                ></Input>
+              </InlineField>
+             )}
+          </InlineFieldRow>
+        )}
   
-        <InlineFormLabel
-          width={this.labelWidth}
-          tooltip="Argument to db.collection.aggregate(...), a JSON array of pipeline stage objects. Helper functions like new Date() or ObjectId() are not supported, consult the MongoDB manual at https://www.mongodb.com/docs/manual/reference/mongodb-extended-json/ to see how to represent these functions in pure JSON"
-        >
-          Aggregation
-        </InlineFormLabel>
-        <CodeEditor
-          height="200px"
-          showLineNumbers={true}
-          language="json"
-          onBlur={this.onAggregationChange}
-          value={query.aggregation}
-        ></CodeEditor>
+        { query.queryType === MongoDBVariableQueryType.Distinct || query.queryType === MongoDBVariableQueryType.Count ? (
+          <>
+            <InlineFormLabel
+              width={this.labelWidth}
+              tooltip="Optional filter document, as Extended JSON"
+            >
+              Filter
+            </InlineFormLabel>
+            <CodeEditor
+              height="200px"
+              showLineNumbers={true}
+              language="json"
+              onBlur={this.onFilterChange}
+              value={query.filter}
+            ></CodeEditor>
+          </>
+        ) : (
+          <>
+            <InlineFormLabel
+              width={this.labelWidth}
+              tooltip="Argument to db.collection.aggregate(...), a JSON array of pipeline stage objects. Helper functions like new Date() or ObjectId() are not supported, consult the MongoDB manual at https://www.mongodb.com/docs/manual/reference/mongodb-extended-json/ to see how to represent these functions in pure JSON"
+            >
+              Aggregation
+            </InlineFormLabel>
+            <CodeEditor
+              height="200px"
+              showLineNumbers={true}
+              language="json"
+              onBlur={this.onAggregationChange}
+              value={query.aggregation}
+            ></CodeEditor>
+          </>
+        ) }
       </>
     );
   }
@@ -1,30 +1,60 @@
-import { lastValueFrom, Observable } from 'rxjs';
+import { lastValueFrom, merge, Observable } from 'rxjs';
 import { 
     DataSourceInstanceSettings,
     DataQueryRequest, 
     DataQueryResponse, 
+    LiveChannelScope,
     MetricFindValue,
     ScopedVars
 } from '@grafana/data';
 import {
     DataSourceWithBackend, 
+    getGrafanaLiveSrv,
     getTemplateSrv,
     frameToMetricFindValue
 } from '@grafana/runtime';
-import { MongoDBDataSourceOptions, MongoDBQuery, MongoDBQueryType, MongoDBVariableQuery } from './types';
+import { MongoDBDataSourceOptions, MongoDBQuery, MongoDBQueryType, MongoDBVariableQuery, MongoDBVariableQueryType } from './types';
+
+// hashQuery produces a short identifier for a query which can be used in a Grafana Live channel path.
+// It is a 64-bit hash of the query JSON, made of two 32-bit multiplicative hashes.
+// The backend recomputes it in hashStreamQuery, and rejects subscriptions where it does not match
+function hashQuery(query: Record<string, any>): string {
+  const json = JSON.stringify(query);
+  let h1 = 0xdeadbeef;
+  let h2 = 0x41c6ce57;
+  for (let i = 0; i < json.length; i++) {
+    const ch = json.charCodeAt(i);
+    h1 = Math.imul(h1 ^ ch, 2654435761);
+    h2 = Math.imul(h2 ^ ch, 1597334677);
+  }
+  h1 = Math.imul(h1 ^ (h1 >>> 16), 2246822507);
+  h1 ^= Math.imul(h2 ^ (h2 >>> 13), 3266489909);
+  h2 = Math.imul(h2 ^ (h2 >>> 16), 2246822507);
+  h2 ^= Math.imul(h1 ^ (h1 >>> 13), 3266489909);
+  return (h2 >>> 0).toString(16).padStart(8, '0') + (h1 >>> 0).toString(16).padStart(8, '0');
+}
 
 export class DataSource extends DataSourceWithBackend<MongoDBQuery, MongoDBDataSourceOptions> {
   constructor(instanceSettings: DataSourceInstanceSettings<MongoDBDataSourceOptions>) {
     super(instanceSettings);
   }
 
+  // Annotations are produced by the Annotations query type, using the regular query editor
+  annotations = {};
+
  applyTemplateVariables(query: MongoDBQuery, scopedVars: ScopedVars): Record<string, any> {
     const templateSrv = getTemplateSrv();
+    // $__interval_ms is expanded by the backend as a macro, so that it produces a number instead of a string
+    const { __interval_ms, ...pipelineScopedVars } = scopedVars;
     return {
       ...query,
       database: query.database ? templateSrv.replace(query.database, scopedVars) : '',
       collection: query.collection ? templateSrv.replace(query.collection, scopedVars) : '',
-      aggregation: query.aggregation ? templateSrv.replace(query.aggregation, scopedVars, 'json') : ''
+      aggregation: query.aggregation ? templateSrv.replace(query.aggregation, pipelineScopedVars, 'json') : '',
+      filter: query.filter ? templateSrv.replace(query.filter, pipelineScopedVars, 'json') : '',
+      projection: query.projection ? templateSrv.replace(query.projection, pipelineScopedVars, 'json') : '',
+      sort: query.sort ? templateSrv.replace(query.sort, pipelineScopedVars, 'json') : '',
+      command: query.command ? templateSrv.replace(query.command, pipelineScopedVars, 'json') : '',
     };
   }
 
@@ -32,11 +62,49 @@ export class DataSource extends DataSourceWithBackend<MongoDBQuery, MongoDBDataS
   query(request: DataQueryRequest<MongoDBQuery>): Observable<DataQueryResponse> {
       const templateSrv = getTemplateSrv();
       templateSrv.updateTimeRange(request.range);
-      return super.query(request);
+
+      // Streamed queries are run by the backend over Grafana Live instead of once per request
+      const streamed = request.targets.filter((target) => !target.hide && target.streamMode);
+      if (streamed.length === 0) {
+        return super.query(request);
+      }
+      const responses = streamed.map((target) => {
+        const data = { ...this.applyTemplateVariables(target, request.scopedVars), refId: target.refId };
+        return getGrafanaLiveSrv().getDataStream({
+          key: `${request.requestId}-${target.refId}`,
+          addr: {
+            scope: LiveChannelScope.DataSource,
+            namespace: this.uid,
+            // Subscribers with identical queries share a stream
+            path: `${target.streamMode}/${hashQuery(data)}`,
+            data,
+          },
+        });
+      });
+      const others = request.targets.filter((target) => !target.streamMode);
+      if (others.length !== 0) {
+        responses.push(super.query({ ...request, targets: others }));
+      }
+      return merge(...responses);
   }
 
   async metricFindQuery(query: MongoDBVariableQuery, options?: any): Promise<MetricFindValue[]> {
-    const target: Partial<MongoDBQuery> = {
+    const target: Partial<MongoDBQuery> = query.queryType === MongoDBVariableQueryType.Distinct ? {
+        refId: 'metricFindQuery',
+        database: query.database,
+        collection: query.collection,
+        queryType: MongoDBQueryType.Distinct,
+        distinctField: query.fieldName,
+        filter: query.filter,
+        autoTimeBound: false,
+    } : query.queryType === MongoDBVariableQueryType.Count ? {
+        refId: 'metricFindQuery',
+        database: query.database,
+        collection: query.collection,
+        queryType: MongoDBQueryType.Count,
+        filter: query.filter,
+        autoTimeBound: false,
+    } : {
         refId: 'metricFindQuery',
         database: query.database,
         collection: query.collection,
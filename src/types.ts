@@ -5,21 +5,85 @@ export interface MongoDBQuery extends DataQuery {
   collection: string;
   timestampField: string;
   timestampFormat: string;
+  timestampType: MongoDBTimestampType;
   labelFields: string[];
   legendFormat: string;
+  sortByLabels: boolean;
   valueFields: string[];
   valueFieldTypes: string[];
   aggregation: string;
+  queryMode: MongoDBQueryMode;
+  filter: string;
+  projection: string;
+  sort: string;
+  skip: number;
+  limit: number;
+  distinctField: string;
+  command: string;
+  commandCursor: boolean;
+  commandResultPath: string;
+  serverStatusReplication: boolean;
+  currentOpMinSecsRunning: number;
+  currentOpNamespace: string;
+  currentOpType: string;
+  profilerTopN: number;
+  profilerRankBy: string;
+  streamMode: MongoDBStreamMode;
+  changeStreamMatch: string;
+  pollIntervalSeconds: number;
+  pollWindowSeconds: number;
+  tailFlushIntervalMs: number;
+  tailBufferSize: number;
+  annotationTimeEndField: string;
+  annotationTitleField: string;
+  annotationTextField: string;
+  annotationTagsField: string;
+  logsBodyField: string;
+  logsLevelField: string;
   autoTimeBound: boolean;
   autoTimeBoundAtStart: boolean;
   autoTimeSort: boolean;
   schemaInference: boolean;
   schemaInferenceDepth: number;
+  schemaInferenceFallbackToString: boolean;
+  flattenDocuments: boolean;
+  flattenMaxDepth: number;
+  expandArrayField: string;
+  autoBucket: boolean;
+  autoBucketReducers: string[];
 }
 
 export enum MongoDBQueryType {
     Timeseries = "Timeseries",
     Table = "Table",
+    Count = "Count",
+    Distinct = "Distinct",
+    Command = "Command",
+    ServerStatus = "ServerStatus",
+    ReplicaSet = "ReplicaSet",
+    IndexStats = "IndexStats",
+    CurrentOp = "CurrentOp",
+    Oplog = "Oplog",
+    Profiler = "Profiler",
+    Annotations = "Annotations",
+    Logs = "Logs",
+};
+
+export enum MongoDBStreamMode {
+    None = "",
+    ChangeStream = "ChangeStream",
+    Poll = "Poll",
+    Tail = "Tail",
+};
+
+export enum MongoDBTimestampType {
+    Date = "Date",
+    Timestamp = "Timestamp",
+};
+
+export enum MongoDBQueryMode {
+    Aggregate = "Aggregate",
+    Find = "Find",
 };
 
 export const defaultQuery: Partial<MongoDBQuery> = {
@@ -28,29 +92,73 @@ export const defaultQuery: Partial<MongoDBQuery> = {
     queryType: MongoDBQueryType.Timeseries,
     timestampField: "timestamp",
     timestampFormat: "",
-    labelFields: [ "sensorID" ],
+    timestampType: MongoDBTimestampType.Date,
+    labelFields: [ "metadata.sensorID" ],
     legendFormat: "",
+    sortByLabels: false,
     valueFields: [ "measurement" ],
     valueFieldTypes: [ "float64" ],
     aggregation: JSON.stringify([
         { 
             "$project": { 
                 "timestamp": 1, 
-                "sensorID": "$metadata.sensorID",
+                "metadata": 1,
                 "measurement": 1, 
                 "_id": 0 
             }
         }
     ]),
+    queryMode: MongoDBQueryMode.Aggregate,
+    filter: "{}",
+    projection: "",
+    sort: "",
+    skip: 0,
+    limit: 0,
+    distinctField: "",
+    command: JSON.stringify({ "dbStats": 1 }),
+    commandCursor: false,
+    commandResultPath: "",
+    serverStatusReplication: false,
+    currentOpMinSecsRunning: 0,
+    currentOpNamespace: "",
+    currentOpType: "",
+    profilerTopN: 10,
+    profilerRankBy: "totalMillis",
+    streamMode: MongoDBStreamMode.None,
+    changeStreamMatch: "",
+    pollIntervalSeconds: 10,
+    pollWindowSeconds: 300,
+    tailFlushIntervalMs: 1000,
+    tailBufferSize: 1000,
+    annotationTimeEndField: "",
+    annotationTitleField: "",
+    annotationTextField: "",
+    annotationTagsField: "",
+    logsBodyField: "message",
+    logsLevelField: "level",
     autoTimeBound: false,
     autoTimeSort: false,
     schemaInference: false,
     schemaInferenceDepth: 20,
+    schemaInferenceFallbackToString: false,
+    flattenDocuments: false,
+    flattenMaxDepth: 0,
+    expandArrayField: "",
+    autoBucket: false,
+    autoBucketReducers: [],
+};
+
+export enum MongoDBVariableQueryType {
+    Aggregate = "Aggregate",
+    Distinct = "Distinct",
+    Count = "Count",
 };
 
 export interface MongoDBVariableQuery {
     database: string;
     collection: string;
+    queryType: MongoDBVariableQueryType;
+    filter: string;
     aggregation: string;
     fieldName: string;
     fieldType: string;
@@ -59,6 +167,8 @@ export interface MongoDBVariableQuery {
 export const defaultVariableQuery: Partial<MongoDBVariableQuery> = {
     database: "my_db",
     collection: "my_collection",
+    queryType: MongoDBVariableQueryType.Aggregate,
+    filter: "{}",
     aggregation: JSON.stringify([
         {"$group":{"_id":"$label", "count": {"$sum":1}}}
     ]),
@@ -76,6 +186,7 @@ export interface MongoDBDataSourceOptions extends DataSourceJsonData {
   tlsCertificate?: string;
   tlsCa?: string;
   tlsServerName?: string;
+  maxConcurrentQueries?: number;
 }
 
 /**